@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Values of qemu.accelerator.
+const (
+	acceleratorAuto = "auto"
+	acceleratorKVM  = "kvm"
+	acceleratorHVF  = "hvf"
+	acceleratorWHPX = "whpx"
+	acceleratorTCG  = "tcg"
+)
+
+// whpxCPU enables Hyper-V enlightenments for better guest performance under WHPX.
+const whpxCPU = "host,hv_relaxed,hv_spinlocks=0x1fff,hv_vapic,hv_time"
+
+// tcgCPUModels are CPU models that emulate well under TCG. aarch64's "max" implements
+// pointer authentication in software, which slows emulation down considerably.
+var tcgCPUModels = map[string]string{
+	"aarch64": "cortex-a72",
+	"x86_64":  "max",
+}
+
+// normalizeArch maps Go and QEMU architecture names onto the names used in vm.architecture.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	}
+	return arch
+}
+
+// hostArch returns the architecture of the machine running container-host.
+func hostArch() string {
+	return normalizeArch(runtime.GOARCH)
+}
+
+// nativeAccelerator returns the hardware accelerator of the host OS.
+func nativeAccelerator() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return acceleratorHVF
+	case "linux":
+		return acceleratorKVM
+	case "windows":
+		return acceleratorWHPX
+	}
+	return acceleratorTCG
+}
+
+// validateAccelerator checks qemu.accelerator.
+func validateAccelerator(config *Config) error {
+	switch config.QEMU.Accelerator {
+	case acceleratorAuto, acceleratorKVM, acceleratorHVF, acceleratorWHPX, acceleratorTCG:
+		return nil
+	}
+	return fmt.Errorf("qemu.accelerator must be one of auto, kvm, hvf, whpx or tcg, got %q", config.QEMU.Accelerator)
+}
+
+// qemuAccelerators returns the accelerators compiled into a QEMU binary, as listed by
+// `-accel help`.
+func qemuAccelerators(qemuPath string) (map[string]bool, error) {
+	out, err := exec.Command(qemuPath, "-accel", "help").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s -accel help failed: %v", qemuPath, err)
+	}
+	accels := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.Contains(line, " ") {
+			accels[line] = true
+		}
+	}
+	return accels, nil
+}
+
+// probeAccelerator reports why an accelerator can't be used on this host, with a hint on
+// how to fix it, or nil when it is available.
+func probeAccelerator(qemuPath, accelerator string) error {
+	accels, err := qemuAccelerators(qemuPath)
+	if err != nil {
+		return err
+	}
+	if !accels[accelerator] {
+		return fmt.Errorf("%s was built without %s support", qemuPath, accelerator)
+	}
+
+	switch accelerator {
+	case acceleratorKVM:
+		f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("/dev/kvm does not exist; enable virtualization in the firmware and load the kvm_intel or kvm_amd module")
+		}
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("no permission to open /dev/kvm; add yourself to the kvm group (sudo usermod -aG kvm $USER) and log in again")
+		}
+		if err != nil {
+			return fmt.Errorf("cannot open /dev/kvm: %v", err)
+		}
+		f.Close()
+	case acceleratorHVF:
+		out, err := exec.Command("sysctl", "-n", "kern.hv_support").Output()
+		if err != nil || strings.TrimSpace(string(out)) != "1" {
+			return fmt.Errorf("Hypervisor.framework is not supported on this Mac (kern.hv_support is not 1)")
+		}
+	}
+	return nil
+}
+
+// chooseAccelerator picks the accelerator for a guest architecture and explains why.
+// Hardware acceleration only works when the guest matches the host architecture and the
+// host grants access to it; otherwise auto falls back to TCG. An accelerator forced with
+// qemu.accelerator must be usable.
+func chooseAccelerator(config *Config, guestArch, qemuPath string) (string, string, error) {
+	if config.QEMU.Accelerator != acceleratorAuto {
+		if err := probeAccelerator(qemuPath, config.QEMU.Accelerator); err != nil {
+			return "", "", fmt.Errorf("qemu.accelerator %q is not usable: %v", config.QEMU.Accelerator, err)
+		}
+		return config.QEMU.Accelerator, "set by qemu.accelerator", nil
+	}
+	if !config.QEMU.EnableAcceleration {
+		return acceleratorTCG, "qemu.enableAcceleration is false", nil
+	}
+	if normalizeArch(guestArch) != hostArch() {
+		return acceleratorTCG, fmt.Sprintf("%s guest on %s host requires emulation", normalizeArch(guestArch), hostArch()), nil
+	}
+
+	native := nativeAccelerator()
+	if native == acceleratorTCG {
+		return acceleratorTCG, fmt.Sprintf("no hardware accelerator is supported on %s", runtime.GOOS), nil
+	}
+	if err := probeAccelerator(qemuPath, native); err != nil {
+		return acceleratorTCG, fmt.Sprintf("%s unavailable: %v", native, err), nil
+	}
+	return native, fmt.Sprintf("native %s guest on %s, %s available", hostArch(), runtime.GOOS, native), nil
+}
+
+// acceleratorArgs returns the -accel and -cpu arguments for the chosen accelerator.
+// qemu.cpu always wins over the per-accelerator CPU defaults.
+func acceleratorArgs(config *Config, prof qemuProfile, guestArch, accelerator string) []string {
+	cpu := prof.cpu
+	var args []string
+	switch accelerator {
+	case acceleratorTCG:
+		args = append(args, "-accel", "tcg,thread=multi")
+		if model, ok := tcgCPUModels[normalizeArch(guestArch)]; ok && config.QEMU.CPU == "" {
+			cpu = model
+		}
+	case acceleratorWHPX:
+		args = append(args, "-accel", acceleratorWHPX)
+		if config.QEMU.CPU == "" {
+			cpu = whpxCPU
+		}
+	default:
+		args = append(args, "-accel", accelerator)
+	}
+	if cpu != "" {
+		args = append(args, "-cpu", cpu)
+	}
+	return args
+}
+
+// printAcceleratorChoice reports the accelerator in use and warns when emulating.
+func printAcceleratorChoice(accelerator, reason string) {
+	fmt.Printf("⚡ Accelerator: %s (%s)\n", accelerator, reason)
+	if accelerator == acceleratorTCG {
+		fmt.Println("⚠️  Running under TCG emulation: expect boot and workloads to be several times slower")
+	}
+}
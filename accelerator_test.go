@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAcceleratorArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		arch        string
+		cpu         string // qemu.cpu override
+		accelerator string
+		want        []string
+	}{
+		{"kvm keeps profile cpu", "x86_64", "", acceleratorKVM, []string{"-accel", "kvm", "-cpu", "max"}},
+		{"tcg picks emulated model", "aarch64", "", acceleratorTCG, []string{"-accel", "tcg,thread=multi", "-cpu", "cortex-a72"}},
+		{"tcg respects cpu override", "aarch64", "max", acceleratorTCG, []string{"-accel", "tcg,thread=multi", "-cpu", "max"}},
+		{"whpx uses its cpu", "x86_64", "", acceleratorWHPX, []string{"-accel", "whpx", "-cpu", whpxCPU}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.QEMU.CPU = tt.cpu
+			got := acceleratorArgs(config, profileForArch(tt.arch, config), tt.arch, tt.accelerator)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acceleratorArgs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// consoleEscape is Ctrl-], which detaches `container-host console` as in telnet and virsh.
+const consoleEscape = 0x1d
+
+// serialLogPath returns the file an instance's serial console is logged to.
+func serialLogPath(instanceIndex int) (string, error) {
+	return filepath.Abs(filepath.Join(instanceDir(instanceIndex), "serial.log"))
+}
+
+// serialLogCheckInterval is how often `up` checks the serial logs of running instances.
+const serialLogCheckInterval = 30 * time.Second
+
+// rotateSerialLog shifts serial.log to serial.log.1 (and older logs up by one) once it
+// exceeds console.maxLogSize, keeping at most console.logFiles rotated logs. QEMU keeps
+// the log open in append mode, so the current log is copied and truncated rather than
+// renamed; output written between the two steps is lost.
+func rotateSerialLog(config *Config, instanceIndex int) error {
+	logPath, err := serialLogPath(instanceIndex)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect serial log: %v", err)
+	}
+	maxSize, err := parseSize(config.Console.MaxLogSize)
+	if err != nil {
+		return fmt.Errorf("console.maxLogSize: %v", err)
+	}
+	if info.Size() <= maxSize {
+		return nil
+	}
+
+	if config.Console.LogFiles > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", logPath, config.Console.LogFiles))
+		for n := config.Console.LogFiles - 1; n >= 1; n-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", logPath, n), fmt.Sprintf("%s.%d", logPath, n+1))
+		}
+		if err := copyFile(logPath, logPath+".1"); err != nil {
+			return fmt.Errorf("failed to rotate serial log: %v", err)
+		}
+	}
+	if err := os.Truncate(logPath, 0); err != nil {
+		return fmt.Errorf("failed to truncate serial log: %v", err)
+	}
+	return nil
+}
+
+// watchSerialLogs rotates the serial logs of running instances for as long as `up` runs,
+// so console.maxLogSize also holds for long-lived instances.
+func watchSerialLogs(config *Config) {
+	for range time.Tick(serialLogCheckInterval) {
+		for i := 0; i < config.VM.Instances; i++ {
+			if err := rotateSerialLog(config, i); err != nil {
+				fmt.Fprintf(os.Stderr, "Error rotating serial log of instance %d: %v\n", i+1, err)
+			}
+		}
+	}
+}
+
+// serialArgs returns the QEMU arguments for an instance's serial console. Instance 1 stays
+// on the terminal running `up`; the others are served on a unix socket for `console`, or
+// only written to the log when console.socket is off. All of them are logged.
+func serialArgs(config *Config, instanceIndex int) ([]string, error) {
+	if err := os.MkdirAll(instanceDir(instanceIndex), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create instance directory: %v", err)
+	}
+	if err := rotateSerialLog(config, instanceIndex); err != nil {
+		return nil, err
+	}
+	logPath, err := serialLogPath(instanceIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	var chardev string
+	switch {
+	case instanceIndex == 0:
+		chardev = fmt.Sprintf("stdio,id=serial0,logfile=%s,logappend=on", logPath)
+	case config.Console.Socket:
+		socketPath, err := instanceSocketPath(instanceIndex, "console.sock")
+		if err != nil {
+			return nil, err
+		}
+		chardev = fmt.Sprintf("socket,id=serial0,path=%s,server=on,wait=off,logfile=%s,logappend=on", socketPath, logPath)
+	default:
+		chardev = fmt.Sprintf("file,id=serial0,path=%s,append=on", logPath)
+	}
+	return []string{"-chardev", chardev, "-serial", "chardev:serial0"}, nil
+}
+
+// printSerialLog writes an instance's serial log to stdout. With follow it keeps printing
+// new output, starting over when the log is rotated or truncated.
+func printSerialLog(instanceIndex int, follow bool) error {
+	logPath, err := serialLogPath(instanceIndex)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("no serial log for instance %d yet: %v", instanceIndex+1, err)
+	}
+	defer func() { file.Close() }()
+
+	for {
+		if _, err := io.Copy(os.Stdout, file); err != nil {
+			return fmt.Errorf("failed to read serial log: %v", err)
+		}
+		if !follow {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+
+		pos, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		current, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		latest, err := os.Stat(logPath)
+		if err != nil {
+			continue // rotated away, the next boot has not created a new log yet
+		}
+		if !os.SameFile(current, latest) {
+			reopened, err := os.Open(logPath)
+			if err != nil {
+				continue
+			}
+			io.Copy(os.Stdout, file) // the rest of the rotated log
+			file.Close()
+			file = reopened
+		} else if latest.Size() < pos {
+			file.Seek(0, io.SeekStart)
+		}
+	}
+}
+
+// attachConsole connects the terminal to a running instance's serial console until the
+// instance stops or the user presses Ctrl-].
+func attachConsole(config *Config, instanceIndex int) error {
+	if instanceIndex == 0 {
+		return fmt.Errorf("instance 1's serial console is attached to the terminal running `up`; use `container-host logs 1` to read it")
+	}
+	if !config.Console.Socket {
+		return fmt.Errorf("console.socket is disabled; use `container-host logs %d` to read the serial log", instanceIndex+1)
+	}
+	socketPath, err := instanceSocketPath(instanceIndex, "console.sock")
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", socketPath, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("instance %d is not running (console socket %s unavailable): %v", instanceIndex+1, socketPath, err)
+	}
+	defer conn.Close()
+
+	restore, err := rawTerminal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Could not switch the terminal to raw mode, input is line buffered: %v\n", err)
+		restore = func() {}
+	}
+	defer restore()
+	fmt.Printf("🔌 Connected to instance %d console, press Enter for a prompt and Ctrl-] to detach\r\n", instanceIndex+1)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(done)
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if i := bytes.IndexByte(buf[:n], consoleEscape); i >= 0 {
+				conn.Write(buf[:i])
+				conn.Close()
+				return
+			}
+			if n > 0 {
+				if _, werr := conn.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	<-done
+	fmt.Printf("\r\n🔌 Detached from instance %d console\r\n", instanceIndex+1)
+	return nil
+}
@@ -0,0 +1,8 @@
+package main
+
+import "fmt"
+
+// rawTerminal is not implemented on Windows; the console stays line buffered.
+func rawTerminal() (func(), error) {
+	return nil, fmt.Errorf("raw mode is not supported on Windows")
+}
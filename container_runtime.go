@@ -0,0 +1,184 @@
+package main
+
+import "fmt"
+
+// Supported container runtimes inside the VM.
+const (
+	runtimeDocker = "docker"
+	runtimePodman = "podman"
+)
+
+// guestAPIPort is where the runtime API is served over TCP inside the guest. Ignition only
+// writes it on first boot, so it is fixed and only the host side of the forward moves.
+const guestAPIPort = 2375
+
+// coreUserUID is the UID Fedora CoreOS assigns to the default core user.
+const coreUserUID = 1000
+
+// runtimeSocketPath returns the guest path of the runtime's API socket.
+func runtimeSocketPath(config *Config) string {
+	if config.Runtime == runtimePodman {
+		if config.Podman.Rootless {
+			return fmt.Sprintf("/run/user/%d/podman/podman.sock", coreUserUID)
+		}
+		return "/run/podman/podman.sock"
+	}
+	return "/var/run/docker.sock"
+}
+
+// runtimeDisplayName returns a human readable runtime name for connection output.
+func runtimeDisplayName(config *Config) string {
+	if config.Runtime == runtimePodman {
+		if config.Podman.Rootless {
+			return "Podman (rootless)"
+		}
+		return "Podman"
+	}
+	return "Docker"
+}
+
+// containerAPIOverTCP reports whether the host reaches the runtime API over plain TCP.
+// User-mode guests are only reachable through the host's forwards, but a tap or bridge
+// guest sits on the LAN, where the unauthenticated API needs network.exposeContainerAPI.
+func containerAPIOverTCP(config *Config) bool {
+	return isUserNetworking(config) || config.Network.ExposeContainerAPI
+}
+
+// guestAPIBindAddress returns the guest address the runtime API listens on.
+func guestAPIBindAddress(config *Config) string {
+	if containerAPIOverTCP(config) {
+		return "0.0.0.0"
+	}
+	return "127.0.0.1"
+}
+
+// runtimeSetupUnits returns the systemd units that enable the runtime and expose its
+// API socket over TCP on apiPort.
+func runtimeSetupUnits(config *Config, apiPort string) []SystemdUnit {
+	if config.Runtime == runtimePodman {
+		return podmanSetupUnits(config, apiPort)
+	}
+	return dockerSetupUnits(config, apiPort)
+}
+
+func dockerSetupUnits(config *Config, dockerPort string) []SystemdUnit {
+	dockerServiceContents := `[Unit]
+Description=Enable and start Docker engine
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/bin/systemctl enable docker.service
+ExecStart=/usr/bin/systemctl start docker.service
+
+[Install]
+WantedBy=multi-user.target`
+
+	dockerTcpServiceContents := fmt.Sprintf(`[Unit]
+Description=Forward Docker socket over TCP
+After=docker.service
+Requires=docker.service
+
+[Service]
+Type=simple
+Restart=always
+RestartSec=5
+ExecStart=/usr/bin/socat TCP-LISTEN:%s,bind=%s,fork,reuseaddr UNIX-CONNECT:/var/run/docker.sock
+
+[Install]
+WantedBy=multi-user.target`, dockerPort, guestAPIBindAddress(config))
+
+	return []SystemdUnit{
+		{
+			Name:     "docker-setup.service",
+			Enabled:  true,
+			Contents: dockerServiceContents,
+		},
+		{
+			Name:     "docker-tcp-proxy.service",
+			Enabled:  true,
+			Contents: dockerTcpServiceContents,
+		},
+	}
+}
+
+func podmanSetupUnits(config *Config, podmanPort string) []SystemdUnit {
+	// Rootful podman uses the system socket unit; rootless enables the core user's
+	// socket through its lingering user manager.
+	podmanServiceContents := `[Unit]
+Description=Enable and start the Podman API socket
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/bin/systemctl enable --now podman.socket
+
+[Install]
+WantedBy=multi-user.target`
+	if config.Podman.Rootless {
+		podmanServiceContents = fmt.Sprintf(`[Unit]
+Description=Enable and start the rootless Podman API socket for core
+After=network-online.target setup-linger-core.service user@%d.service
+Wants=network-online.target setup-linger-core.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/bin/systemctl --user -M core@ enable --now podman.socket
+
+[Install]
+WantedBy=multi-user.target`, coreUserUID)
+	}
+
+	podmanTcpServiceContents := fmt.Sprintf(`[Unit]
+Description=Forward Podman socket over TCP
+After=podman-setup.service
+Requires=podman-setup.service
+
+[Service]
+Type=simple
+Restart=always
+RestartSec=5
+ExecStart=/usr/bin/socat TCP-LISTEN:%s,bind=%s,fork,reuseaddr UNIX-CONNECT:%s
+
+[Install]
+WantedBy=multi-user.target`, podmanPort, guestAPIBindAddress(config), runtimeSocketPath(config))
+
+	return []SystemdUnit{
+		{
+			Name:     "podman-setup.service",
+			Enabled:  true,
+			Contents: podmanServiceContents,
+		},
+		{
+			Name:     "podman-tcp-proxy.service",
+			Enabled:  true,
+			Contents: podmanTcpServiceContents,
+		},
+	}
+}
+
+// printRuntimeConnectionHints prints how to reach an instance's container API from the host.
+func printRuntimeConnectionHints(config *Config, instanceIndex int, address, sshPort, apiPort string) {
+	overTCP := containerAPIOverTCP(config)
+	if config.Runtime == runtimePodman {
+		if overTCP {
+			fmt.Printf("  Host Access: export CONTAINER_HOST=tcp://%s:%s\n", address, apiPort)
+		}
+		fmt.Printf("  Podman Connection: podman system connection add %s-%d --identity %s ssh://core@%s:%s%s\n",
+			config.DockerContext.NamePrefix, instanceIndex+1, config.SSH.PrivateKeyPath, address, sshPort, runtimeSocketPath(config))
+		if overTCP {
+			fmt.Printf("  Docker-compatible Access: export DOCKER_HOST=tcp://%s:%s\n", address, apiPort)
+		}
+		return
+	}
+	if !overTCP {
+		fmt.Printf("  Host Access: export DOCKER_HOST=ssh://core@%s:%s\n", address, sshPort)
+		return
+	}
+	fmt.Printf("  Host Access: export DOCKER_HOST=tcp://%s:%s\n", address, apiPort)
+}
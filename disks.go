@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// dataDiskNamePattern limits names to what fits a virtio serial (20 bytes), which the
+// guest exposes as /dev/disk/by-id/virtio-<name>.
+var dataDiskNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,20}$`)
+
+// filesystemLabelLimits is the longest label each filesystem accepts. Formatted disks use
+// their name as the label.
+var filesystemLabelLimits = map[string]int{"xfs": 12, "ext4": 16}
+
+// validateDataDisks applies defaults to vm.disks and rejects invalid entries.
+func validateDataDisks(config *Config) error {
+	names := map[string]bool{}
+	for i := range config.VM.Disks {
+		d := &config.VM.Disks[i]
+		if !dataDiskNamePattern.MatchString(d.Name) {
+			return fmt.Errorf("vm.disks[%d]: name %q must be 1-20 letters, digits, '-' or '_'", i, d.Name)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("vm.disks: duplicate disk name %q", d.Name)
+		}
+		names[d.Name] = true
+		if d.Size == "" {
+			return fmt.Errorf("vm.disks %q: size is required (for example \"20G\")", d.Name)
+		}
+		if d.Format == "" {
+			d.Format = "qcow2"
+		}
+		if d.Format != "qcow2" && d.Format != "raw" {
+			return fmt.Errorf("vm.disks %q: format must be qcow2 or raw, got %q", d.Name, d.Format)
+		}
+		if d.Filesystem == "" {
+			d.Filesystem = "xfs"
+		}
+		if d.Filesystem != "xfs" && d.Filesystem != "ext4" {
+			return fmt.Errorf("vm.disks %q: filesystem must be xfs or ext4, got %q", d.Name, d.Filesystem)
+		}
+		if limit := filesystemLabelLimits[d.Filesystem]; d.MountPoint != "" && len(d.Name) > limit {
+			return fmt.Errorf("vm.disks %q: name is used as the %s label and must be at most %d characters", d.Name, d.Filesystem, limit)
+		}
+		if d.MountPoint != "" && (!strings.HasPrefix(d.MountPoint, "/") || d.MountPoint == "/") {
+			return fmt.Errorf("vm.disks %q: mountPoint %q must be an absolute path other than /", d.Name, d.MountPoint)
+		}
+	}
+	return nil
+}
+
+// dataDiskDevice is the stable guest path of a data disk.
+func dataDiskDevice(d DataDisk) string {
+	return "/dev/disk/by-id/virtio-" + d.Name
+}
+
+// dataDiskPath returns the image file of a data disk for an instance.
+func dataDiskPath(instanceIndex int, d DataDisk) string {
+	return filepath.Join(instanceDir(instanceIndex), fmt.Sprintf("disk-%s.%s", d.Name, d.Format))
+}
+
+// ensureDataDisks creates missing data disk images for an instance and returns the QEMU
+// arguments attaching them. Deleting an image resets just that disk on the next start.
+func ensureDataDisks(config *Config, instanceIndex int) ([]string, error) {
+	var args []string
+	for _, d := range config.VM.Disks {
+		path, err := filepath.Abs(dataDiskPath(instanceIndex, d))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve disk %s: %v", d.Name, err)
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			qemuImg, err := exec.LookPath("qemu-img")
+			if err != nil {
+				return nil, fmt.Errorf("qemu-img not found in PATH: %v", err)
+			}
+			out, err := exec.Command(qemuImg, "create", "-f", d.Format, path, d.Size).CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("qemu-img create for disk %s failed: %v: %s", d.Name, err, strings.TrimSpace(string(out)))
+			}
+			fmt.Printf("💾 Created instance %d data disk %s (%s): %s\n", instanceIndex+1, d.Name, d.Size, path)
+		}
+		args = append(args, "-drive", fmt.Sprintf("file=%s,format=%s,if=virtio,serial=%s", path, d.Format, d.Name))
+	}
+	return args, nil
+}
+
+// dataDiskFilesystems returns the ignition filesystems formatting data disks on first boot.
+// The whole device is used, so a reset disk needs no partition table to be recreated.
+func dataDiskFilesystems(config *Config) []StorageFilesystem {
+	var filesystems []StorageFilesystem
+	for _, d := range config.VM.Disks {
+		if d.MountPoint == "" {
+			continue
+		}
+		filesystems = append(filesystems, StorageFilesystem{
+			Device:         dataDiskDevice(d),
+			Format:         d.Filesystem,
+			Label:          d.Name,
+			WipeFilesystem: false,
+		})
+	}
+	return filesystems
+}
+
+// dataDiskUnits returns units mounting data disks at boot. Ignition only runs on first
+// boot, so a format unit recreates the filesystem of a disk that was reset since. It also
+// creates the mount point, which outside /var needs the immutable root lifted like mounts.
+func dataDiskUnits(config *Config) []SystemdUnit {
+	var units []SystemdUnit
+	for _, d := range config.VM.Disks {
+		if d.MountPoint == "" {
+			continue
+		}
+		device := dataDiskDevice(d)
+		where := guestMountPoint(d.MountPoint)
+		formatUnit := fmt.Sprintf("container-host-mkfs-%s.service", d.Name)
+		deviceUnit := systemdEscapePath(device) + ".device"
+
+		units = append(units,
+			SystemdUnit{
+				Name:    formatUnit,
+				Enabled: false,
+				Contents: fmt.Sprintf(`[Unit]
+Description=Create filesystem and mount point for data disk %[1]s if missing
+DefaultDependencies=no
+BindsTo=%[2]s
+After=%[2]s
+Before=local-fs-pre.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh -c 'blkid %[3]s || mkfs.%[4]s -L %[1]s %[3]s'
+ExecStart=%[5]s
+`, d.Name, deviceUnit, device, d.Filesystem, mkdirCommand([]string{where})),
+			},
+			SystemdUnit{
+				Name:    systemdEscapePath(where) + ".mount",
+				Enabled: true,
+				Contents: fmt.Sprintf(`[Unit]
+Description=Data disk %s
+Requires=%s
+After=%s
+
+[Mount]
+What=%s
+Where=%s
+Type=%s
+
+[Install]
+RequiredBy=local-fs.target
+`, d.Name, formatUnit, formatUnit, device, where, d.Filesystem),
+			},
+		)
+	}
+	return units
+}
@@ -0,0 +1,8 @@
+package main
+
+import "fmt"
+
+// freeDiskSpace is not implemented on Windows; callers skip the check.
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("free space check not supported on Windows")
+}
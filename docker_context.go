@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// dockerContextMeta mirrors the meta.json layout used by the Docker CLI context store.
+type dockerContextMeta struct {
+	Name      string                           `json:"Name"`
+	Metadata  dockerContextMetadata            `json:"Metadata"`
+	Endpoints map[string]dockerContextEndpoint `json:"Endpoints"`
+}
+
+type dockerContextMetadata struct {
+	Description string `json:"Description,omitempty"`
+}
+
+type dockerContextEndpoint struct {
+	Host          string `json:"Host"`
+	SkipTLSVerify bool   `json:"SkipTLSVerify"`
+}
+
+// dockerConfigDir returns the Docker CLI configuration directory, honoring DOCKER_CONFIG.
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// dockerContextID returns the directory name the Docker CLI uses for a context.
+func dockerContextID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// dockerContextName returns the context name for a zero-based instance index.
+func dockerContextName(config *Config, instanceIndex int) string {
+	return fmt.Sprintf("%s-%d", config.DockerContext.NamePrefix, instanceIndex+1)
+}
+
+// dockerContextHost builds the endpoint URL for an instance's context.
+func dockerContextHost(config *Config, address, sshPort, dockerPort string) string {
+	if config.DockerContext.Endpoint == "ssh" {
+		return fmt.Sprintf("ssh://core@%s:%s", address, sshPort)
+	}
+	return fmt.Sprintf("tcp://%s:%s", address, dockerPort)
+}
+
+// writeDockerContext creates or updates a Docker CLI context pointing at an instance.
+func writeDockerContext(config *Config, instanceIndex int, sshPort, dockerPort string) (string, error) {
+	dockerDir, err := dockerConfigDir()
+	if err != nil {
+		return "", err
+	}
+	address, err := instanceAddress(config, instanceIndex)
+	if err != nil {
+		return "", err
+	}
+
+	name := dockerContextName(config, instanceIndex)
+	id := dockerContextID(name)
+
+	meta := dockerContextMeta{
+		Name: name,
+		Metadata: dockerContextMetadata{
+			Description: fmt.Sprintf("container-host instance %d (%s)", instanceIndex+1, runtimeDisplayName(config)),
+		},
+		Endpoints: map[string]dockerContextEndpoint{
+			"docker": {
+				Host: dockerContextHost(config, address, sshPort, dockerPort),
+			},
+		},
+	}
+
+	metaDir := filepath.Join(dockerDir, "contexts", "meta", id)
+	if err := os.MkdirAll(metaDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create context directory: %v", err)
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal context metadata: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(metaDir, "meta.json"), metaBytes, 0644); err != nil {
+		return "", fmt.Errorf("failed to write context metadata: %v", err)
+	}
+
+	// Contexts written by earlier versions may carry TLS material that no longer applies
+	if err := os.RemoveAll(filepath.Join(dockerDir, "contexts", "tls", id)); err != nil {
+		return "", fmt.Errorf("failed to reset context TLS directory: %v", err)
+	}
+
+	return name, nil
+}
+
+// readDockerCLIConfig reads config.json as raw fields so unknown settings survive a rewrite.
+func readDockerCLIConfig(dockerDir string) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	data, err := os.ReadFile(filepath.Join(dockerDir, "config.json"))
+	if os.IsNotExist(err) {
+		return fields, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Docker config: %v", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return fields, nil
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse Docker config: %v", err)
+	}
+	return fields, nil
+}
+
+func writeDockerCLIConfig(dockerDir string, fields map[string]json.RawMessage) error {
+	data, err := json.MarshalIndent(fields, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal Docker config: %v", err)
+	}
+	if err := os.MkdirAll(dockerDir, 0755); err != nil {
+		return fmt.Errorf("failed to create Docker config directory: %v", err)
+	}
+	return os.WriteFile(filepath.Join(dockerDir, "config.json"), data, 0600)
+}
+
+// currentDockerContext returns the context selected in the Docker CLI config, if any.
+func currentDockerContext(dockerDir string) (string, error) {
+	fields, err := readDockerCLIConfig(dockerDir)
+	if err != nil {
+		return "", err
+	}
+	var current string
+	if raw, ok := fields["currentContext"]; ok {
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return "", fmt.Errorf("failed to parse currentContext: %v", err)
+		}
+	}
+	return current, nil
+}
+
+// setCurrentDockerContext selects a context, or resets to the default one when name is empty.
+func setCurrentDockerContext(name string) error {
+	dockerDir, err := dockerConfigDir()
+	if err != nil {
+		return err
+	}
+	fields, err := readDockerCLIConfig(dockerDir)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		delete(fields, "currentContext")
+	} else {
+		raw, _ := json.Marshal(name)
+		fields["currentContext"] = raw
+	}
+	return writeDockerCLIConfig(dockerDir, fields)
+}
+
+// removeDockerContexts deletes every context created for the given prefix and
+// resets the current context if it pointed at one of them.
+func removeDockerContexts(prefix string) ([]string, error) {
+	dockerDir, err := dockerConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	metaRoot := filepath.Join(dockerDir, "contexts", "meta")
+	entries, err := os.ReadDir(metaRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Docker contexts: %v", err)
+	}
+
+	namePattern := regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + `-[0-9]+$`)
+	var removed []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(metaRoot, entry.Name(), "meta.json"))
+		if err != nil {
+			continue
+		}
+		var meta dockerContextMeta
+		if err := json.Unmarshal(data, &meta); err != nil || !namePattern.MatchString(meta.Name) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(metaRoot, entry.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove context %s: %v", meta.Name, err)
+		}
+		_ = os.RemoveAll(filepath.Join(dockerDir, "contexts", "tls", entry.Name()))
+		removed = append(removed, meta.Name)
+	}
+
+	current, err := currentDockerContext(dockerDir)
+	if err != nil {
+		return removed, err
+	}
+	if namePattern.MatchString(current) {
+		if err := setCurrentDockerContext(""); err != nil {
+			return removed, fmt.Errorf("failed to reset current Docker context: %v", err)
+		}
+	}
+
+	return removed, nil
+}
+
+// configureDockerContext writes an instance's context, prints how to use it and, for the
+// first instance, selects it when dockerContext.setCurrent is set.
+func configureDockerContext(config *Config, instanceIndex int, sshPort, dockerPort string) error {
+	contextName, err := writeDockerContext(config, instanceIndex, sshPort, dockerPort)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Docker Context: %s (connect with: docker --context %s ps)\n", contextName, contextName)
+	if config.DockerContext.Endpoint == "ssh" && instanceIndex == 0 {
+		fmt.Printf("  SSH contexts use your ssh client; load the key first: ssh-add %s\n", config.SSH.PrivateKeyPath)
+	}
+	if config.DockerContext.SetCurrent && instanceIndex == 0 {
+		if err := setCurrentDockerContext(contextName); err != nil {
+			return fmt.Errorf("failed to select Docker context %s: %v", contextName, err)
+		}
+		fmt.Printf("  Docker Context %s is now the current context\n", contextName)
+	}
+	return nil
+}
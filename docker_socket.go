@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// closeWriter is implemented by connections that support half-close (TCP and unix sockets).
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// defaultDockerSocketPath mirrors Docker's rootless/Desktop locations for the relay socket.
+func defaultDockerSocketPath() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "docker.sock")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".docker", "run", "docker.sock")
+	}
+	return filepath.Join(os.TempDir(), "container-host-docker.sock")
+}
+
+// listenDockerSocket creates the host unix socket, clearing a stale one left by a previous run.
+func listenDockerSocket(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket; remove it or choose another dockerSocket.path", socketPath)
+		}
+		if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is already in use by another process", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %v", socketPath, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, 0660); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %v", err)
+	}
+	return listener, nil
+}
+
+// serveDockerSocket accepts connections on the listener and relays each one to target.
+// The relay is byte-for-byte, so HTTP upgrades used by attach/exec pass through untouched.
+// The target is resolved per connection since tap and bridge guests get their address after boot.
+func serveDockerSocket(listener net.Listener, config *Config) error {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go relayDockerConnection(client, config)
+	}
+}
+
+func relayDockerConnection(client net.Conn, config *Config) {
+	defer client.Close()
+	verbose := config.Debug.Verbose
+
+	target, err := dockerSocketTarget(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Docker socket relay: %v\n", err)
+		return
+	}
+	upstream, err := dialDockerTarget(config, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Docker socket relay: failed to reach %s: %v\n", target, err)
+		return
+	}
+	defer upstream.Close()
+
+	if verbose {
+		fmt.Printf("Docker socket relay: new connection → %s\n", target)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		// Propagate EOF so hijacked streams (attach/exec) see stdin close.
+		if cw, ok := dst.(closeWriter); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go pipe(upstream, client)
+	go pipe(client, upstream)
+	wg.Wait()
+}
+
+// startDockerSocketRelay listens on the configured socket and relays to the selected
+// instance's Docker endpoint in the background. The returned func stops the relay.
+func startDockerSocketRelay(config *Config) (func(), error) {
+	if instance := config.DockerSocket.Instance; instance < 1 || instance > config.VM.Instances {
+		return nil, fmt.Errorf("dockerSocket.instance %d is out of range (1-%d)", instance, config.VM.Instances)
+	}
+
+	listener, err := listenDockerSocket(config.DockerSocket.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_ = serveDockerSocket(listener, config)
+	}()
+
+	return func() {
+		listener.Close()
+		_ = os.Remove(config.DockerSocket.Path)
+	}, nil
+}
+
+// dockerSocketTarget resolves the TCP endpoint of the instance selected for the relay.
+// When the API is only served on guest loopback this is the guest-side address, which
+// dialDockerTarget reaches through SSH.
+func dockerSocketTarget(config *Config) (string, error) {
+	instance := config.DockerSocket.Instance
+	if instance < 1 || instance > config.VM.Instances {
+		return "", fmt.Errorf("dockerSocket.instance %d is out of range (1-%d)", instance, config.VM.Instances)
+	}
+	if !containerAPIOverTCP(config) {
+		return net.JoinHostPort("127.0.0.1", strconv.Itoa(guestAPIPort)), nil
+	}
+	port, err := instancePort(config, portDocker, instance-1)
+	if err != nil {
+		return "", err
+	}
+	address, err := instanceAddress(config, instance-1)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(address, port), nil
+}
+
+// dialDockerTarget connects to the relay target, tunnelling through an SSH connection to
+// the selected instance when the API is not exposed over TCP.
+func dialDockerTarget(config *Config, target string) (net.Conn, error) {
+	if containerAPIOverTCP(config) {
+		return net.DialTimeout("tcp", target, 10*time.Second)
+	}
+	client, err := dialInstanceSSH(config, config.DockerSocket.Instance-1)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sshTunnelConn{Conn: conn, client: client}, nil
+}
+
+// sshTunnelConn is a connection forwarded over its own SSH client, which it closes too.
+type sshTunnelConn struct {
+	net.Conn
+	client *ssh.Client
+}
+
+func (c *sshTunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+func (c *sshTunnelConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
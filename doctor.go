@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// minImageSpace is the free space needed to download and extract a CoreOS image and
+// create instance overlays.
+const minImageSpace = 5 << 30
+
+// doctorReport collects check results for `container-host doctor`.
+type doctorReport struct {
+	failures int
+}
+
+func (r *doctorReport) ok(check, detail string) {
+	fmt.Printf("✅ %s: %s\n", check, detail)
+}
+
+func (r *doctorReport) warn(check, detail, fix string) {
+	fmt.Printf("⚠️  %s: %s\n", check, detail)
+	if fix != "" {
+		fmt.Printf("   Fix: %s\n", fix)
+	}
+}
+
+func (r *doctorReport) fail(check, detail, fix string) {
+	r.failures++
+	fmt.Printf("❌ %s: %s\n", check, detail)
+	if fix != "" {
+		fmt.Printf("   Fix: %s\n", fix)
+	}
+}
+
+// qemuInstallHint suggests how to install QEMU for the guest architecture on this host.
+func qemuInstallHint(binary string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "brew install qemu"
+	case "windows":
+		return "install QEMU from https://www.qemu.org/download/#windows and add it to PATH"
+	}
+	return fmt.Sprintf("install the package providing %s (e.g. qemu-system-x86 / qemu-system-arm on Debian and Ubuntu, qemu-system-x86-core / qemu-system-aarch64-core on Fedora)", binary)
+}
+
+// runDoctorChecks verifies host prerequisites for the loaded configuration and returns
+// the number of failed checks.
+func runDoctorChecks(config *Config) int {
+	r := &doctorReport{}
+	arch := config.VM.Architecture
+	prof := profileForArch(arch, config)
+
+	qemuPath, err := exec.LookPath(prof.binary)
+	if err != nil {
+		r.fail("QEMU", fmt.Sprintf("%s not found in PATH", prof.binary), qemuInstallHint(prof.binary))
+	} else {
+		r.ok("QEMU", qemuPath)
+	}
+	if path, err := exec.LookPath("qemu-img"); err != nil {
+		r.fail("qemu-img", "not found in PATH", "install qemu-img (usually shipped with QEMU, or the qemu-utils / qemu-img package)")
+	} else {
+		r.ok("qemu-img", path)
+	}
+
+	if fw, err := selectFirmware(config, prof); err != nil && config.QEMU.SecureBoot {
+		r.fail("Firmware", err.Error(), "install Secure Boot firmware (ovmf or qemu-efi-aarch64 on Debian/Ubuntu, edk2-ovmf on Fedora), or set qemu.firmware.code and qemu.firmware.vars")
+	} else if err != nil {
+		r.fail("Firmware", err.Error(), "point qemu.firmware.code and qemu.firmware.vars at existing files")
+	} else if fw.pflash {
+		r.ok("Firmware", fw.describe())
+	} else if fw.code != "" {
+		r.warn("Firmware", fw.describe(), "install a firmware package that ships a matching VARS template, or set qemu.firmware.vars")
+	} else if normalizeArch(arch) == "aarch64" {
+		r.fail("Firmware", "no aarch64 UEFI firmware found", "install edk2 (qemu-efi-aarch64 on Debian/Ubuntu, edk2-aarch64 on Fedora; bundled with Homebrew QEMU)")
+	} else {
+		r.warn("Firmware", "no UEFI firmware found, QEMU's built-in BIOS will be used", "install OVMF (ovmf on Debian/Ubuntu, edk2-ovmf on Fedora) for UEFI boot")
+	}
+
+	if path, err := exec.LookPath("xz"); err == nil {
+		r.ok("xz", path)
+	} else if path, err := exec.LookPath("unxz"); err == nil {
+		r.ok("xz", path)
+	} else {
+		r.warn("xz", "xz/unxz not found, image extraction falls back to a slower built-in decoder", "install xz (xz-utils on Debian/Ubuntu)")
+	}
+
+	if len(config.Mounts) > 0 {
+		if mountShareType() == shareVirtiofs {
+			r.ok("virtiofsd", findVirtiofsd())
+		} else if runtime.GOOS == "linux" {
+			r.warn("virtiofsd", "not found, mounts fall back to slower 9p", "install virtiofsd")
+		} else {
+			r.ok("Mounts", "using 9p (virtiofs needs a Linux host)")
+		}
+	}
+	if config.QEMU.TPM {
+		if path, err := exec.LookPath("swtpm"); err == nil {
+			r.ok("swtpm", path)
+		} else {
+			r.fail("swtpm", "not found in PATH, required by qemu.tpm", "install swtpm (swtpm-tools on Debian/Ubuntu, swtpm on Fedora, brew install swtpm on macOS)")
+		}
+	}
+	if config.Network.RequestedBackend == networkBackendPasst {
+		// loadConfig has already fallen back to slirp if passt can't be used
+		if runtime.GOOS != "linux" {
+			r.warn("passt", "network.backend \"passt\" is only available on Linux, slirp is used instead", "set network.backend to \"slirp\"")
+		} else if path, err := exec.LookPath("passt"); err == nil {
+			r.ok("passt", path)
+		} else {
+			r.warn("passt", "not found in PATH, slirp is used instead", "install passt (passt on Debian/Ubuntu and Fedora)")
+		}
+	}
+
+	if qemuPath != "" {
+		accelerator, reason, err := chooseAccelerator(config, arch, qemuPath)
+		switch {
+		case err != nil:
+			r.fail("Acceleration", err.Error(), "set qemu.accelerator to \"auto\" or fix the problem above")
+		case accelerator == acceleratorTCG && config.QEMU.EnableAcceleration && normalizeArch(arch) == hostArch() && config.QEMU.Accelerator == acceleratorAuto:
+			r.warn("Acceleration", "falling back to TCG emulation: "+reason, "fix the accelerator problem above for near-native speed")
+		default:
+			r.ok("Acceleration", fmt.Sprintf("%s (%s)", accelerator, reason))
+		}
+	}
+
+	if cwd, err := os.Getwd(); err == nil {
+		image := filepath.Join(cwd, "images", fmt.Sprintf("coreos-%s-qemu.%s.qcow2", config.VM.Version, arch))
+		if _, err := os.Stat(image); err == nil {
+			r.ok("CoreOS image", image)
+		} else if free, err := freeDiskSpace(cwd); err == nil {
+			if free < minImageSpace {
+				r.fail("Disk space", fmt.Sprintf("%s free in %s, the CoreOS image needs about %s", humanizeBytes(int64(free)), cwd, humanizeBytes(minImageSpace)), "free up disk space or run container-host from a larger filesystem")
+			} else {
+				r.ok("Disk space", fmt.Sprintf("%s free for the CoreOS image download", humanizeBytes(int64(free))))
+			}
+		}
+	}
+
+	if _, err := os.Stat(config.SSH.PrivateKeyPath); err == nil {
+		r.ok("SSH key", config.SSH.PrivateKeyPath)
+	} else {
+		r.ok("SSH key", fmt.Sprintf("%s will be generated on first start", config.SSH.PrivateKeyPath))
+	}
+
+	checkDoctorPorts(r, config)
+	return r.failures
+}
+
+// checkDoctorPorts verifies that the fixed host ports of stopped instances can be bound.
+// Running instances hold their own ports, so they are skipped.
+func checkDoctorPorts(r *doctorReport, config *Config) {
+	if err := checkPortRanges(config); err != nil {
+		r.fail("Ports", err.Error(), "change the base ports in the network section or use \"auto\"")
+		return
+	}
+	if !isUserNetworking(config) {
+		r.ok("Ports", fmt.Sprintf("network.mode %q binds no forwarded host ports", config.Network.Mode))
+		return
+	}
+
+	busy := 0
+	for i := 0; i < config.VM.Instances; i++ {
+		if qmp, err := dialQMP(i); err == nil {
+			qmp.Close()
+			r.ok("Ports", fmt.Sprintf("instance %d is running, skipping its ports", i+1))
+			continue
+		}
+		for _, spec := range configuredPorts(config) {
+			if spec.base == autoPort || !spec.appliesTo(i) {
+				continue
+			}
+			port, err := spec.hostPortFor(i)
+			if err != nil {
+				continue
+			}
+			if err := checkPortAvailable(spec.protocol, spec.bindAddress, port); err != nil {
+				busy++
+				r.fail("Ports", fmt.Sprintf("instance %d %s port: %v", i+1, spec.label, err), fmt.Sprintf("stop the process using it or set the port to %q", autoPort))
+			}
+		}
+	}
+	if busy == 0 {
+		r.ok("Ports", "all fixed host ports are free")
+	}
+}
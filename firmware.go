@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// firmwarePair is a UEFI CODE image with the VARS template it was built with. Both must
+// match for the variable store to be usable.
+type firmwarePair struct {
+	code string
+	vars string
+}
+
+// firmware is the boot firmware chosen for a launch: pflash CODE plus a per-instance VARS
+// copy when a pair is found, otherwise a read-only -bios image (or QEMU's default).
+type firmware struct {
+	code       string
+	vars       string // template copied per instance; empty when booting with -bios
+	pflash     bool
+	secureBoot bool
+	smm        bool // the machine needs smm=on and a secure-only VARS flash
+}
+
+// selectFirmware picks the firmware for a profile. qemu.firmware.code and
+// qemu.firmware.vars override discovery.
+func selectFirmware(config *Config, prof qemuProfile) (firmware, error) {
+	if config.QEMU.SecureBoot {
+		return selectSecureBootFirmware(config, prof)
+	}
+	if code := config.QEMU.Firmware.Code; code != "" {
+		if _, err := os.Stat(code); err != nil {
+			return firmware{}, fmt.Errorf("qemu.firmware.code: %v", err)
+		}
+		vars := config.QEMU.Firmware.Vars
+		if vars == "" {
+			return firmware{code: code}, nil
+		}
+		if _, err := os.Stat(vars); err != nil {
+			return firmware{}, fmt.Errorf("qemu.firmware.vars: %v", err)
+		}
+		return firmware{code: code, vars: vars, pflash: true}, nil
+	}
+
+	for _, pair := range prof.pflashCandidates {
+		if findFirstExisting(pair.code) != "" && findFirstExisting(pair.vars) != "" {
+			return firmware{code: pair.code, vars: pair.vars, pflash: true}, nil
+		}
+	}
+	return firmware{code: findFirstExisting(prof.biosCandidates...)}, nil
+}
+
+// selectSecureBootFirmware picks firmware for qemu.secureBoot. There is no -bios fallback:
+// booting without enrolled keys would silently disable Secure Boot.
+func selectSecureBootFirmware(config *Config, prof qemuProfile) (firmware, error) {
+	fw := firmware{pflash: true, secureBoot: true, smm: prof.secureBootSMM}
+	if code := config.QEMU.Firmware.Code; code != "" {
+		vars := config.QEMU.Firmware.Vars
+		if vars == "" {
+			return firmware{}, fmt.Errorf("qemu.secureBoot needs qemu.firmware.vars to point at a VARS template with keys enrolled")
+		}
+		for _, path := range []string{code, vars} {
+			if _, err := os.Stat(path); err != nil {
+				return firmware{}, fmt.Errorf("qemu.firmware: %v", err)
+			}
+		}
+		fw.code, fw.vars = code, vars
+		return fw, nil
+	}
+
+	for _, pair := range prof.secureBoot {
+		if findFirstExisting(pair.code) != "" && findFirstExisting(pair.vars) != "" {
+			fw.code, fw.vars = pair.code, pair.vars
+			return fw, nil
+		}
+	}
+	return firmware{}, fmt.Errorf("qemu.secureBoot is enabled but no Secure Boot firmware with enrolled keys was found for %s", prof.binary)
+}
+
+// describe summarizes the firmware for startup output and doctor.
+func (fw firmware) describe() string {
+	switch {
+	case fw.secureBoot:
+		return fmt.Sprintf("UEFI Secure Boot %s with per-instance variables from %s", fw.code, fw.vars)
+	case fw.pflash:
+		return fmt.Sprintf("UEFI %s with per-instance variables from %s", fw.code, fw.vars)
+	case fw.code != "":
+		return fmt.Sprintf("%s via -bios (EFI variables are not persisted)", fw.code)
+	}
+	return "QEMU default"
+}
+
+// instanceVarsPath returns the writable EFI variable store of an instance. Secure Boot
+// keeps its own store since it starts from a different template.
+func instanceVarsPath(instanceIndex int, secureBoot bool) (string, error) {
+	name := "efi-vars.qcow2"
+	if secureBoot {
+		name = "efi-vars-secboot.qcow2"
+	}
+	return filepath.Abs(filepath.Join(instanceDir(instanceIndex), name))
+}
+
+// firmwareArgs returns the firmware arguments for an instance, creating its VARS store
+// from the template on first use. The store is qcow2 so internal snapshots still work.
+func firmwareArgs(fw firmware, instanceIndex int) ([]string, error) {
+	if !fw.pflash {
+		if fw.code == "" {
+			return nil, nil
+		}
+		return []string{"-bios", fw.code}, nil
+	}
+
+	varsPath, err := instanceVarsPath(instanceIndex, fw.secureBoot)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(varsPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(varsPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create instance directory: %v", err)
+		}
+		out, err := exec.Command("qemu-img", "convert", "-f", "raw", "-O", "qcow2", fw.vars, varsPath).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create EFI variable store: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	args := []string{
+		"-drive", fmt.Sprintf("if=pflash,format=raw,unit=0,readonly=on,file=%s", fw.code),
+		"-drive", fmt.Sprintf("if=pflash,format=qcow2,unit=1,file=%s", varsPath),
+	}
+	if fw.smm {
+		// Only SMM code may write the variables, so the guest OS cannot replace the keys
+		args = append(args, "-global", "driver=cfi.pflash01,property=secure,value=on")
+	}
+	return args, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// stateRoot holds per-instance runtime state (disks, sockets, metadata).
+const stateRoot = "state"
+
+// instanceDir returns the state directory for a zero-based instance index.
+func instanceDir(instanceIndex int) string {
+	return filepath.Join(stateRoot, fmt.Sprintf("instance-%d", instanceIndex+1))
+}
+
+// instanceState is persisted per instance in state.json so later commands (and later
+// boots) see the same assignments.
+type instanceState struct {
+	Ports    map[string]string `json:"ports,omitempty"`
+	Forwards []runtimeForward  `json:"forwards,omitempty"`
+	GuestIP  string            `json:"guestIP,omitempty"` // discovered on tap and bridge networks
+	MACs     map[string]string `json:"macs,omitempty"`    // keyed by netdev id (net0, net1, ...)
+
+	// LaunchHash fingerprints the last QEMU command line; SuspendedHash is set when the
+	// instance was suspended under it.
+	LaunchHash    string `json:"launchHash,omitempty"`
+	SuspendedHash string `json:"suspendedHash,omitempty"`
+
+	// IgnitionHash fingerprints the Ignition config the instance was provisioned with.
+	IgnitionHash string `json:"ignitionHash,omitempty"`
+}
+
+func instanceStatePath(instanceIndex int) string {
+	return filepath.Join(instanceDir(instanceIndex), "state.json")
+}
+
+// loadInstanceState reads an instance's state, returning an empty state if none exists yet.
+func loadInstanceState(instanceIndex int) (*instanceState, error) {
+	state := &instanceState{}
+	data, err := os.ReadFile(instanceStatePath(instanceIndex))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance %d state: %v", instanceIndex+1, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse instance %d state: %v", instanceIndex+1, err)
+	}
+	return state, nil
+}
+
+func saveInstanceState(instanceIndex int, state *instanceState) error {
+	if err := os.MkdirAll(instanceDir(instanceIndex), 0755); err != nil {
+		return fmt.Errorf("failed to create instance directory: %v", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal instance %d state: %v", instanceIndex+1, err)
+	}
+	return os.WriteFile(instanceStatePath(instanceIndex), data, 0644)
+}
+
+// projectID identifies this container-host checkout, so instances of different projects
+// on one host (or one bridge) get different MAC addresses.
+func projectID() (string, error) {
+	dir, err := filepath.Abs(stateRoot)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve state directory: %v", err)
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// instanceMAC returns the MAC address of NIC netN of an instance. It is derived from the
+// project and instance on first use and recorded in state, so the instance keeps it when
+// recreated. QEMU would otherwise give every NIC 52:54:00:12:34:56.
+func instanceMAC(instanceIndex, nic int) (string, error) {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return "", err
+	}
+	netdev := fmt.Sprintf("net%d", nic)
+	if mac := state.MACs[netdev]; mac != "" {
+		return mac, nil
+	}
+
+	id, err := projectID()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/instance-%d/%s", id, instanceIndex+1, netdev)))
+	mac := fmt.Sprintf("52:54:00:%02x:%02x:%02x", sum[0], sum[1], sum[2])
+
+	if state.MACs == nil {
+		state.MACs = map[string]string{}
+	}
+	state.MACs[netdev] = mac
+	return mac, saveInstanceState(instanceIndex, state)
+}
+
+// parseSize converts a qemu-img style size ("20G", "512M", "10737418240") to bytes.
+func parseSize(size string) (int64, error) {
+	units := map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	s := strings.TrimSpace(size)
+	multiplier := int64(1)
+	if s != "" {
+		if m, ok := units[strings.ToUpper(s[len(s)-1:])[0]]; ok {
+			multiplier = m
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 20G or 512M)", size)
+	}
+	return n * multiplier, nil
+}
+
+// imageVirtualSize returns the virtual size of a disk image in bytes.
+func imageVirtualSize(path string) (int64, error) {
+	out, err := exec.Command("qemu-img", "info", "--output=json", "-U", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("qemu-img info %s failed: %v", path, err)
+	}
+	var info struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return 0, fmt.Errorf("failed to parse qemu-img info for %s: %v", path, err)
+	}
+	return info.VirtualSize, nil
+}
+
+// checkDiskSize rejects a vm.diskSize smaller than the base image, which cannot boot.
+func checkDiskSize(baseImage, diskSize string) error {
+	if diskSize == "" {
+		return nil
+	}
+	want, err := parseSize(diskSize)
+	if err != nil {
+		return err
+	}
+	base, err := imageVirtualSize(baseImage)
+	if err != nil {
+		return err
+	}
+	if want < base {
+		return fmt.Errorf("vm.diskSize %s is smaller than the base image (%d bytes)", diskSize, base)
+	}
+	return nil
+}
+
+// checkIgnitionUnchanged refuses an existing instance whose rendered Ignition config
+// differs from the one it was provisioned with: Ignition only runs on first boot, so the
+// change would silently not apply. New instances, and ones provisioned before the
+// fingerprint was kept, record it instead.
+func checkIgnitionUnchanged(instanceIndex int, ignition string) error {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(ignition))
+	hash := hex.EncodeToString(sum[:])
+	_, statErr := os.Stat(filepath.Join(instanceDir(instanceIndex), "disk.qcow2"))
+	provisioned := statErr == nil
+	if provisioned && state.IgnitionHash == hash {
+		return nil
+	}
+	if provisioned && state.IgnitionHash != "" {
+		return fmt.Errorf("instance %d was provisioned with a different Ignition config and Ignition only runs on first boot; revert the change (SSH key, runtime, mounts, data disks, private network, ...) or run 'container-host destroy' to recreate the instances", instanceIndex+1)
+	}
+	state.IgnitionHash = hash
+	return saveInstanceState(instanceIndex, state)
+}
+
+// ensureInstanceDisk creates a qcow2 overlay backed by the shared CoreOS image so
+// every instance gets its own writable disk. With a diskSize the overlay gets a larger
+// virtual size, which CoreOS fills on first boot; existing overlays are grown too, but
+// their root filesystem has to be grown from inside the guest.
+func ensureInstanceDisk(baseImage, diskSize string, instanceIndex int) (string, error) {
+	dir := instanceDir(instanceIndex)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create instance directory: %v", err)
+	}
+
+	diskPath, err := filepath.Abs(filepath.Join(dir, "disk.qcow2"))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve instance disk path: %v", err)
+	}
+	qemuImg, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return "", fmt.Errorf("qemu-img not found in PATH: %v", err)
+	}
+
+	if _, err := os.Stat(diskPath); err == nil {
+		if diskSize == "" {
+			return diskPath, nil
+		}
+		want, err := parseSize(diskSize)
+		if err != nil {
+			return "", err
+		}
+		current, err := imageVirtualSize(diskPath)
+		if err != nil {
+			return "", err
+		}
+		if want < current {
+			fmt.Printf("⚠️  Instance %d disk is already larger than vm.diskSize %s; disks are never shrunk\n", instanceIndex+1, diskSize)
+		} else if want > current {
+			out, err := exec.Command(qemuImg, "resize", diskPath, strconv.FormatInt(want, 10)).CombinedOutput()
+			if err != nil {
+				return "", fmt.Errorf("qemu-img resize failed: %v: %s", err, strings.TrimSpace(string(out)))
+			}
+			fmt.Printf("💾 Grew instance %d disk to %s; grow the root filesystem in the guest with: sudo growpart /dev/vda 4 && sudo xfs_growfs /sysroot\n", instanceIndex+1, diskSize)
+		}
+		return diskPath, nil
+	}
+
+	args := []string{"create", "-f", "qcow2", "-F", "qcow2", "-b", baseImage, diskPath}
+	if diskSize != "" {
+		want, err := parseSize(diskSize)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, strconv.FormatInt(want, 10))
+	}
+	out, err := exec.Command(qemuImg, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("qemu-img create failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("💾 Created instance %d disk: %s\n", instanceIndex+1, diskPath)
+	return diskPath, nil
+}
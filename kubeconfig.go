@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// kubeconfigName is used for the cluster, user and context entries we generate.
+const kubeconfigName = "container-host"
+
+// kubeconfig is the subset of the kubeconfig schema we rewrite; entry bodies are kept
+// as generic maps so fields we don't know about survive a round trip.
+type kubeconfig struct {
+	APIVersion     string                 `json:"apiVersion"`
+	Kind           string                 `json:"kind"`
+	Preferences    map[string]interface{} `json:"preferences"`
+	Clusters       []kubeconfigCluster    `json:"clusters"`
+	Users          []kubeconfigUser       `json:"users"`
+	Contexts       []kubeconfigContext    `json:"contexts"`
+	CurrentContext string                 `json:"current-context,omitempty"`
+}
+
+type kubeconfigCluster struct {
+	Name    string                 `json:"name"`
+	Cluster map[string]interface{} `json:"cluster"`
+}
+
+type kubeconfigUser struct {
+	Name string                 `json:"name"`
+	User map[string]interface{} `json:"user"`
+}
+
+type kubeconfigContext struct {
+	Name    string                 `json:"name"`
+	Context map[string]interface{} `json:"context"`
+}
+
+// fetchAdminKubeconfig reads the admin kubeconfig from the k0s controller as JSON.
+func fetchAdminKubeconfig(config *Config, container string) (*kubeconfig, error) {
+	client, err := dialInstanceSSH(config, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to instance 1: %v", err)
+	}
+	defer client.Close()
+
+	// kubectl flattens the admin config to JSON, which avoids parsing YAML on the host.
+	cmd := fmt.Sprintf("sudo %s exec %s k0s kubectl --kubeconfig /var/lib/k0s/pki/admin.conf config view --raw -o json", config.Runtime, container)
+	out, err := runSSHCommand(client, cmd, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin kubeconfig: %v", err)
+	}
+
+	var kc kubeconfig
+	if err := json.Unmarshal([]byte(out), &kc); err != nil {
+		return nil, fmt.Errorf("failed to parse admin kubeconfig: %v", err)
+	}
+	if len(kc.Clusters) == 0 || len(kc.Users) == 0 {
+		return nil, fmt.Errorf("admin kubeconfig has no cluster or user entries")
+	}
+	return &kc, nil
+}
+
+// rewriteKubeconfig points the kubeconfig at the host-forwarded API port and renames
+// its entries so they don't collide with other clusters when merged.
+func rewriteKubeconfig(kc *kubeconfig, server string) *kubeconfig {
+	cluster := kc.Clusters[0].Cluster
+	cluster["server"] = server
+
+	return &kubeconfig{
+		APIVersion:  "v1",
+		Kind:        "Config",
+		Preferences: map[string]interface{}{},
+		Clusters:    []kubeconfigCluster{{Name: kubeconfigName, Cluster: cluster}},
+		Users:       []kubeconfigUser{{Name: kubeconfigName + "-admin", User: kc.Users[0].User}},
+		Contexts: []kubeconfigContext{{
+			Name: kubeconfigName,
+			Context: map[string]interface{}{
+				"cluster": kubeconfigName,
+				"user":    kubeconfigName + "-admin",
+			},
+		}},
+		CurrentContext: kubeconfigName,
+	}
+}
+
+func writeKubeconfig(path string, kc *kubeconfig) error {
+	data, err := json.MarshalIndent(kc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal kubeconfig: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create kubeconfig directory: %v", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// defaultKubeconfigPath returns ~/.kube/config, or the first entry of $KUBECONFIG.
+func defaultKubeconfigPath() (string, error) {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return filepath.SplitList(env)[0], nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".kube", "config"), nil
+}
+
+// mergeKubeconfig replaces our entries in the kubeconfig at targetPath, keeping everything
+// else and a .bak copy of the original. JSON kubeconfigs are merged directly; YAML ones
+// are flattened with kubectl.
+func mergeKubeconfig(targetPath, generatedPath string, kc *kubeconfig) error {
+	existing, err := os.ReadFile(targetPath)
+	if os.IsNotExist(err) {
+		return writeKubeconfig(targetPath, kc)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", targetPath, err)
+	}
+
+	if err := copyFile(targetPath, targetPath+".bak"); err != nil {
+		return fmt.Errorf("failed to back up %s: %v", targetPath, err)
+	}
+
+	// Only the entry lists are rewritten; other top-level fields such as extensions are
+	// carried over as they are.
+	var fields map[string]json.RawMessage
+	var current kubeconfig
+	if json.Unmarshal(existing, &fields) == nil && json.Unmarshal(existing, &current) == nil {
+		mergeKubeconfigEntries(&current, kc)
+		merged := map[string]interface{}{
+			"clusters":        current.Clusters,
+			"users":           current.Users,
+			"contexts":        current.Contexts,
+			"current-context": current.CurrentContext,
+		}
+		for key, value := range merged {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("failed to marshal kubeconfig %s: %v", key, err)
+			}
+			fields[key] = raw
+		}
+		data, err := json.MarshalIndent(fields, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal kubeconfig: %v", err)
+		}
+		return os.WriteFile(targetPath, append(data, '\n'), 0600)
+	}
+
+	kubectl, err := exec.LookPath("kubectl")
+	if err != nil {
+		return fmt.Errorf("%s is YAML and kubectl is not in PATH to merge it; use %s directly", targetPath, generatedPath)
+	}
+
+	// Earlier files win on conflicts, so put ours first but leave current-context alone.
+	withoutCurrent := *kc
+	withoutCurrent.CurrentContext = ""
+	tmp, err := os.CreateTemp("", "container-host-kubeconfig-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp kubeconfig: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+	if err := writeKubeconfig(tmp.Name(), &withoutCurrent); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(kubectl, "config", "view", "--flatten")
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+strings.Join([]string{tmp.Name(), targetPath}, string(os.PathListSeparator)))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("kubectl config view failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.WriteFile(targetPath, stdout.Bytes(), 0600)
+}
+
+func mergeKubeconfigEntries(dst, src *kubeconfig) {
+	for _, c := range src.Clusters {
+		kept := dst.Clusters[:0]
+		for _, e := range dst.Clusters {
+			if e.Name != c.Name {
+				kept = append(kept, e)
+			}
+		}
+		dst.Clusters = append(kept, c)
+	}
+	for _, u := range src.Users {
+		kept := dst.Users[:0]
+		for _, e := range dst.Users {
+			if e.Name != u.Name {
+				kept = append(kept, e)
+			}
+		}
+		dst.Users = append(kept, u)
+	}
+	for _, c := range src.Contexts {
+		kept := dst.Contexts[:0]
+		for _, e := range dst.Contexts {
+			if e.Name != c.Name {
+				kept = append(kept, e)
+			}
+		}
+		dst.Contexts = append(kept, c)
+	}
+	if dst.CurrentContext == "" {
+		dst.CurrentContext = src.CurrentContext
+	}
+}
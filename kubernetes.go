@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// slirpHostAddress is the address at which a slirp guest reaches the host, and
+// slirpGuestAddress the guest's own address behind the NAT.
+const (
+	slirpHostAddress  = "10.0.2.2"
+	slirpGuestAddress = "10.0.2.15"
+)
+
+const (
+	k0sControllerContainer = "k0s-controller"
+	k0sWorkerContainer     = "k0s-worker"
+)
+
+// k0sClusterConfig returns the k0s ClusterConfig for the controller. Workers reach the
+// controller at externalAddress: instance 1's private IP when the private network is
+// enabled, otherwise the host, which forwards instance 1's API ports into its VM.
+func k0sClusterConfig(externalAddress string) string {
+	return fmt.Sprintf(`apiVersion: k0s.k0sproject.io/v1beta1
+kind: ClusterConfig
+metadata:
+  name: container-host
+spec:
+  api:
+    externalAddress: %s
+    sans:
+      - %s
+      - localhost
+      - 127.0.0.1
+`, externalAddress, externalAddress)
+}
+
+// k0sNodeName returns the hostname used for the k0s container on an instance.
+func k0sNodeName(instanceIndex int) string {
+	return fmt.Sprintf("container-host-%d", instanceIndex+1)
+}
+
+// k0sControllerAddress returns the address workers use to reach the controller. A single
+// user-mode instance uses its own NAT address rather than the host's forwards, whose
+// host ports may differ from the in-guest API and konnectivity ports.
+func k0sControllerAddress(config *Config) (string, error) {
+	if config.Network.Private.Enabled {
+		return privateAddress(config, 0)
+	}
+	if !isUserNetworking(config) {
+		return instanceAddress(config, 0)
+	}
+	return slirpGuestAddress, nil
+}
+
+// k0sNodeIPArgs pins the kubelet to the private NIC so nodes advertise addresses
+// other instances can reach, rather than the identical slirp address.
+func k0sNodeIPArgs(config *Config, instanceIndex int) (string, error) {
+	if !config.Network.Private.Enabled {
+		return "", nil
+	}
+	address, err := privateAddress(config, instanceIndex)
+	if err != nil {
+		return "", err
+	}
+	return " --kubelet-extra-args=--node-ip=" + address, nil
+}
+
+// k0sRunArgs returns the shared container flags needed to run k0s inside the VM.
+func k0sRunArgs(config *Config, name string, instanceIndex int) string {
+	return strings.Join([]string{
+		"sudo", config.Runtime, "run", "-d",
+		"--name", name,
+		"--hostname", k0sNodeName(instanceIndex),
+		"--restart", "unless-stopped",
+		"--network", "host",
+		"--privileged",
+		"--cgroupns", "host",
+		"-v", "/var/lib/k0s",
+		"-v", "/var/log/pods",
+		"-v", "/dev/kmsg:/dev/kmsg:ro",
+		"-v", "/etc/k0s:/etc/k0s:ro",
+		"--tmpfs", "/run",
+	}, " ")
+}
+
+// k0sContainerExists reports whether a k0s container was already created on the instance.
+func k0sContainerExists(client *ssh.Client, config *Config, name string) bool {
+	_, err := runSSHCommand(client, fmt.Sprintf("sudo %s container inspect %s", config.Runtime, name), nil)
+	return err == nil
+}
+
+// bootstrapKubernetes provisions instance 1 as a k0s controller and joins every other
+// instance as a worker using a token generated on the controller.
+func bootstrapKubernetes(config *Config) error {
+	timeout := time.Duration(config.Kubernetes.BootstrapTimeout) * time.Second
+
+	fmt.Println("☸️  Waiting for instance 1 to accept SSH...")
+	controller, err := waitForInstanceSSH(config, 0, timeout)
+	if err != nil {
+		return err
+	}
+	defer controller.Close()
+
+	controllerAddress, err := k0sControllerAddress(config)
+	if err != nil {
+		return err
+	}
+	if _, err := runSSHCommand(controller, "sudo install -D -m 0644 /dev/stdin /etc/k0s/k0s.yaml", strings.NewReader(k0sClusterConfig(controllerAddress))); err != nil {
+		return fmt.Errorf("failed to write k0s config: %v", err)
+	}
+
+	if k0sContainerExists(controller, config, k0sControllerContainer) {
+		fmt.Println("☸️  k0s controller already provisioned on instance 1")
+	} else {
+		controllerCmd := k0sRunArgs(config, k0sControllerContainer, 0) + " " + config.Kubernetes.K0sImage + " k0s controller --config /etc/k0s/k0s.yaml"
+		if config.VM.Instances == 1 {
+			// A single instance has no workers, so let the controller schedule workloads.
+			controllerCmd += " --enable-worker --no-taints"
+			nodeIPArgs, err := k0sNodeIPArgs(config, 0)
+			if err != nil {
+				return err
+			}
+			controllerCmd += nodeIPArgs
+		}
+		if _, err := runSSHCommand(controller, controllerCmd, nil); err != nil {
+			return fmt.Errorf("failed to start k0s controller: %v", err)
+		}
+		fmt.Println("☸️  Started k0s controller on instance 1")
+	}
+
+	if config.VM.Instances == 1 {
+		return nil
+	}
+
+	token, err := createK0sWorkerToken(controller, config, timeout)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i < config.VM.Instances; i++ {
+		if err := joinK0sWorker(config, i, token, timeout); err != nil {
+			return fmt.Errorf("instance %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// createK0sWorkerToken waits for the controller API and returns a worker join token.
+func createK0sWorkerToken(controller *ssh.Client, config *Config, timeout time.Duration) (string, error) {
+	tokenCmd := fmt.Sprintf("sudo %s exec %s k0s token create --role=worker --expiry=1h", config.Runtime, k0sControllerContainer)
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := runSSHCommand(controller, tokenCmd, nil)
+		if token := strings.TrimSpace(out); err == nil && token != "" {
+			return token, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timed out waiting for k0s controller to issue a worker token: %v", err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// joinK0sWorker copies the join token to an instance and starts the k0s worker there.
+func joinK0sWorker(config *Config, instanceIndex int, token string, timeout time.Duration) error {
+	client, err := waitForInstanceSSH(config, instanceIndex, timeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if k0sContainerExists(client, config, k0sWorkerContainer) {
+		fmt.Printf("☸️  k0s worker already joined on instance %d\n", instanceIndex+1)
+		return nil
+	}
+
+	if _, err := runSSHCommand(client, "sudo install -D -m 0600 /dev/stdin /etc/k0s/worker-token", strings.NewReader(token)); err != nil {
+		return fmt.Errorf("failed to copy worker token: %v", err)
+	}
+	nodeIPArgs, err := k0sNodeIPArgs(config, instanceIndex)
+	if err != nil {
+		return err
+	}
+	workerCmd := k0sRunArgs(config, k0sWorkerContainer, instanceIndex) + " " + config.Kubernetes.K0sImage + " k0s worker --token-file /etc/k0s/worker-token" + nodeIPArgs
+	if _, err := runSSHCommand(client, workerCmd, nil); err != nil {
+		return fmt.Errorf("failed to start k0s worker: %v", err)
+	}
+	fmt.Printf("☸️  Instance %d joined the cluster as a k0s worker\n", instanceIndex+1)
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -10,6 +11,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -21,40 +23,120 @@ import (
 )
 
 type Config struct {
+	Runtime string `json:"runtime"`
+	Podman  struct {
+		Rootless bool `json:"rootless"`
+	} `json:"podman"`
 	VM struct {
-		Architecture string `json:"architecture"`
-		Version      string `json:"version"`
-		Memory       string `json:"memory"`
-		CPUs         string `json:"cpus"`
-		Image        string `json:"image"`
-		Instances    int    `json:"instances"`
+		Architecture string     `json:"architecture"`
+		Version      string     `json:"version"`
+		Memory       string     `json:"memory"`
+		CPUs         string     `json:"cpus"`
+		Image        string     `json:"image"`
+		Instances    int        `json:"instances"`
+		Disks        []DataDisk `json:"disks"`
+		DiskSize     string     `json:"diskSize"`
 	} `json:"vm"`
 	Network struct {
-		SSHPort        string `json:"sshPort"`
-		VNCPort        string `json:"vncPort"`
-		DockerPort     string `json:"dockerPort"`
-		HTTPPort       string `json:"httpPort"`
-		KubernetesPort string `json:"kubernetesPort"`
-		K0sPort        string `json:"k0sPort"`
+		SSHPort            string        `json:"sshPort"`
+		VNCPort            string        `json:"vncPort"`
+		DockerPort         string        `json:"dockerPort"`
+		HTTPPort           string        `json:"httpPort"`
+		KubernetesPort     string        `json:"kubernetesPort"`
+		K0sPort            string        `json:"k0sPort"`
+		BindAddress        string        `json:"bindAddress"`
+		KonnectivityPort   string        `json:"konnectivityPort"`
+		Forwards           []PortForward `json:"forwards"`
+		Mode               string        `json:"mode"`
+		Backend            string        `json:"backend"`
+		RequestedBackend   string        `json:"-"` // backend as configured, before falling back to slirp
+		Bridge             string        `json:"bridge"`
+		BridgeHelper       string        `json:"bridgeHelper"`
+		TapDevices         []string      `json:"tapDevices"`
+		ExposeContainerAPI bool          `json:"exposeContainerAPI"` // tap/bridge: serve the API on the LAN, not just guest loopback
+		Private            struct {
+			Enabled      bool   `json:"enabled"`
+			Subnet       string `json:"subnet"`
+			McastAddress string `json:"mcastAddress"`
+		} `json:"private"`
 	} `json:"network"`
 	SSH struct {
 		PublicKeyPath  string `json:"publicKeyPath"`
 		PrivateKeyPath string `json:"privateKeyPath"`
 	} `json:"ssh"`
 	QEMU struct {
-		EnableAcceleration bool     `json:"enableAcceleration"`
-		CustomArgs         []string `json:"customArgs"`
+		EnableAcceleration bool   `json:"enableAcceleration"`
+		Accelerator        string `json:"accelerator"` // auto, kvm, hvf, whpx or tcg
+		Machine            string `json:"machine"`     // overrides the per-architecture default
+		CPU                string `json:"cpu"`         // overrides the per-architecture default
+		Firmware           struct {
+			Code string `json:"code"` // UEFI CODE image
+			Vars string `json:"vars"` // VARS template matching Code; without it Code is passed via -bios
+		} `json:"firmware"`
+		SecureBoot bool     `json:"secureBoot"` // boot firmware whose VARS template has Secure Boot keys enrolled
+		TPM        bool     `json:"tpm"`        // attach a TPM 2.0 backed by a per-instance swtpm
+		CustomArgs []string `json:"customArgs"`
 	} `json:"qemu"`
+	DockerContext struct {
+		Enabled    bool   `json:"enabled"`
+		SetCurrent bool   `json:"setCurrent"`
+		Endpoint   string `json:"endpoint"`
+		NamePrefix string `json:"namePrefix"`
+	} `json:"dockerContext"`
+	Kubernetes struct {
+		Enabled          bool   `json:"enabled"`
+		K0sImage         string `json:"k0sImage"`
+		BootstrapTimeout int    `json:"bootstrapTimeout"`
+	} `json:"kubernetes"`
+	Mounts       []Mount `json:"mounts"`
+	DockerSocket struct {
+		Enabled  bool   `json:"enabled"`
+		Path     string `json:"path"`
+		Instance int    `json:"instance"`
+	} `json:"dockerSocket"`
+	Console struct {
+		Socket     bool   `json:"socket"`     // serve instances 2..N's serial console on a unix socket
+		MaxLogSize string `json:"maxLogSize"` // serial.log is rotated once larger than this
+		LogFiles   int    `json:"logFiles"`   // number of rotated serial logs kept
+	} `json:"console"`
 	Debug struct {
 		PrintIgnitionConfig bool `json:"printIgnitionConfig"`
 		Verbose             bool `json:"verbose"`
 	} `json:"debug"`
 }
 
+// DataDisk is an extra disk attached to every instance from vm.disks.
+type DataDisk struct {
+	Name       string `json:"name"`
+	Size       string `json:"size"`       // qemu-img size, e.g. "20G"
+	Format     string `json:"format"`     // qcow2 (default) or raw
+	MountPoint string `json:"mountPoint"` // left unformatted and unmounted when empty
+	Filesystem string `json:"filesystem"` // xfs (default) or ext4
+}
+
+// Mount shares a host directory with every instance.
+type Mount struct {
+	HostPath  string `json:"hostPath"`
+	GuestPath string `json:"guestPath"` // defaults to hostPath
+	ReadOnly  bool   `json:"readOnly"`
+}
+
+// PortForward is an additional host-to-guest port forward from network.forwards.
+type PortForward struct {
+	Name        string `json:"name"`
+	HostPort    string `json:"hostPort"`
+	GuestPort   int    `json:"guestPort"`
+	Protocol    string `json:"protocol"`
+	BindAddress string `json:"bindAddress"`
+	Offset      *int   `json:"offset"`
+	Instances   []int  `json:"instances"`
+}
+
 // Ignition configuration structures
 type IgnitionConfig struct {
 	Ignition IgnitionSection `json:"ignition"`
 	Passwd   PasswdSection   `json:"passwd"`
+	Storage  StorageSection  `json:"storage"`
 	Systemd  SystemdSection  `json:"systemd"`
 }
 
@@ -71,6 +153,29 @@ type User struct {
 	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys"`
 }
 
+type StorageSection struct {
+	Files       []StorageFile       `json:"files,omitempty"`
+	Filesystems []StorageFilesystem `json:"filesystems,omitempty"`
+}
+
+type StorageFilesystem struct {
+	Device         string `json:"device"`
+	Format         string `json:"format"`
+	Label          string `json:"label,omitempty"`
+	WipeFilesystem bool   `json:"wipeFilesystem"`
+}
+
+type StorageFile struct {
+	Path      string       `json:"path"`
+	Mode      int          `json:"mode"`
+	Overwrite bool         `json:"overwrite"`
+	Contents  FileContents `json:"contents"`
+}
+
+type FileContents struct {
+	Source string `json:"source"`
+}
+
 type SystemdSection struct {
 	Units []SystemdUnit `json:"units"`
 }
@@ -81,8 +186,8 @@ type SystemdUnit struct {
 	Contents string `json:"contents"`
 }
 
-// createIgnitionConfig creates an Ignition configuration with SSH key for core user and Docker engine setup
-func createIgnitionConfig(sshPublicKey string, dockerPort string) (string, error) {
+// createIgnitionConfig creates an Ignition configuration with SSH key for core user and container runtime setup
+func createIgnitionConfig(config *Config, instanceIndex int, sshPublicKey string) (string, error) {
 	setupLinger := `[Unit]
 Description=Enable linger for user 'core' (start user manager at boot)
 After=network.target
@@ -94,33 +199,6 @@ ExecStart=/usr/bin/loginctl enable-linger core
 [Install]
 WantedBy=multi-user.target
 `
-	dockerServiceContents := `[Unit]
-Description=Enable and start Docker engine
-After=network-online.target
-Wants=network-online.target
-
-[Service]
-Type=oneshot
-RemainAfterExit=yes
-ExecStart=/usr/bin/systemctl enable docker.service
-ExecStart=/usr/bin/systemctl start docker.service
-
-[Install]
-WantedBy=multi-user.target`
-
-	dockerTcpServiceContents := fmt.Sprintf(`[Unit]
-Description=Forward Docker socket over TCP
-After=docker.service
-Requires=docker.service
-
-[Service]
-Type=simple
-Restart=always
-RestartSec=5
-ExecStart=/usr/bin/socat TCP-LISTEN:%s,bind=0.0.0.0,fork,reuseaddr UNIX-CONNECT:/var/run/docker.sock
-
-[Install]
-WantedBy=multi-user.target`, dockerPort)
 	disableZincatiServiceContents := `[Unit]
 Description=Disable Zincati automatic updates
 DefaultDependencies=no
@@ -133,7 +211,28 @@ ExecStart=/usr/bin/systemctl mask zincati.service
 [Install]
 WantedBy=multi-user.target`
 
-	config := IgnitionConfig{
+	units := runtimeSetupUnits(config, strconv.Itoa(guestAPIPort))
+	units = append(units,
+		SystemdUnit{
+			Name:     "disable-zincati.service",
+			Enabled:  true,
+			Contents: disableZincatiServiceContents,
+		},
+		SystemdUnit{Name: "setup-linger-core.service", Enabled: true, Contents: setupLinger},
+	)
+	units = append(units, mountUnits(config)...)
+	units = append(units, dataDiskUnits(config)...)
+
+	var files []StorageFile
+	if config.Network.Private.Enabled {
+		privateFile, err := privateNetworkFile(config, instanceIndex)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, privateFile)
+	}
+
+	ignition := IgnitionConfig{
 		Ignition: IgnitionSection{
 			Version: "3.4.0",
 		},
@@ -145,29 +244,16 @@ WantedBy=multi-user.target`
 				},
 			},
 		},
+		Storage: StorageSection{
+			Files:       files,
+			Filesystems: dataDiskFilesystems(config),
+		},
 		Systemd: SystemdSection{
-			Units: []SystemdUnit{
-				{
-					Name:     "docker-setup.service",
-					Enabled:  true,
-					Contents: dockerServiceContents,
-				},
-				{
-					Name:     "docker-tcp-proxy.service",
-					Enabled:  true,
-					Contents: dockerTcpServiceContents,
-				},
-				{
-					Name:     "disable-zincati.service",
-					Enabled:  true,
-					Contents: disableZincatiServiceContents,
-				},
-				{Name: "setup-linger-core.service", Enabled: true, Contents: setupLinger},
-			},
+			Units: units,
 		},
 	}
 
-	configBytes, err := json.Marshal(config)
+	configBytes, err := json.Marshal(ignition)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal ignition config: %v", err)
 	}
@@ -263,19 +349,44 @@ func calculatePort(basePort string, instanceOffset int) (string, error) {
 }
 
 type qemuProfile struct {
-	binary         string
-	machine        string
-	cpu            string
-	biosCandidates []string
+	binary           string
+	machine          string
+	cpu              string
+	globals          []string       // -global options that only exist on this architecture
+	biosCandidates   []string       // read-only images for -bios when no pflash pair exists
+	pflashCandidates []firmwarePair // UEFI CODE images with their matching VARS templates
+	secureBoot       []firmwarePair // Secure Boot CODE images with VARS templates that have keys enrolled
+	secureBootSMM    bool           // Secure Boot firmware relies on SMM to protect its variables
 }
 
-func profileForArch(arch string) qemuProfile {
+// profileForArch returns the QEMU defaults for a guest architecture, with qemu.machine
+// and qemu.cpu from the configuration taking precedence.
+func profileForArch(arch string, config *Config) qemuProfile {
+	prof := defaultProfileForArch(arch)
+	if config.QEMU.Machine != "" {
+		prof.machine = config.QEMU.Machine
+	}
+	if config.QEMU.CPU != "" {
+		prof.cpu = config.QEMU.CPU
+	}
+	return prof
+}
+
+func defaultProfileForArch(arch string) qemuProfile {
 	switch arch {
 	case "aarch64":
 		return qemuProfile{
 			binary:  "qemu-system-aarch64",
 			machine: "virt",
 			cpu:     "max",
+			pflashCandidates: []firmwarePair{
+				{"/opt/homebrew/share/qemu/edk2-aarch64-code.fd", "/opt/homebrew/share/qemu/edk2-arm-vars.fd"},
+				{"/usr/share/edk2/aarch64/QEMU_EFI-pflash.raw", "/usr/share/edk2/aarch64/vars-template-pflash.raw"},
+				{"/usr/share/AAVMF/AAVMF_CODE.fd", "/usr/share/AAVMF/AAVMF_VARS.fd"},
+			},
+			secureBoot: []firmwarePair{
+				{"/usr/share/AAVMF/AAVMF_CODE.ms.fd", "/usr/share/AAVMF/AAVMF_VARS.ms.fd"},
+			},
 			biosCandidates: []string{
 				"/opt/homebrew/share/qemu/edk2-aarch64-code.fd",
 				"/usr/share/edk2/aarch64/QEMU_EFI.fd",
@@ -286,8 +397,22 @@ func profileForArch(arch string) qemuProfile {
 	case "x86_64", "amd64":
 		return qemuProfile{
 			binary:  "qemu-system-x86_64",
-			machine: "virt",
+			machine: "q35",
 			cpu:     "max",
+			// The in-kernel PIT only exists on x86; discarding lost ticks avoids clock drift
+			globals: []string{"kvm-pit.lost_tick_policy=discard"},
+			pflashCandidates: []firmwarePair{
+				{"/opt/homebrew/share/qemu/edk2-x86_64-code.fd", "/opt/homebrew/share/qemu/edk2-i386-vars.fd"},
+				{"/usr/share/OVMF/OVMF_CODE_4M.fd", "/usr/share/OVMF/OVMF_VARS_4M.fd"},
+				{"/usr/share/OVMF/OVMF_CODE.fd", "/usr/share/OVMF/OVMF_VARS.fd"},
+				{"/usr/share/edk2/ovmf/OVMF_CODE.fd", "/usr/share/edk2/ovmf/OVMF_VARS.fd"},
+			},
+			secureBoot: []firmwarePair{
+				{"/usr/share/OVMF/OVMF_CODE_4M.secboot.fd", "/usr/share/OVMF/OVMF_VARS_4M.ms.fd"},
+				{"/usr/share/OVMF/OVMF_CODE.secboot.fd", "/usr/share/OVMF/OVMF_VARS.ms.fd"},
+				{"/usr/share/edk2/ovmf/OVMF_CODE.secboot.fd", "/usr/share/edk2/ovmf/OVMF_VARS.secboot.fd"},
+			},
+			secureBootSMM: true,
 			biosCandidates: []string{
 				"/opt/homebrew/share/qemu/edk2-x86_64-code.fd",
 				"/usr/share/OVMF/OVMF_CODE.fd",
@@ -299,7 +424,7 @@ func profileForArch(arch string) qemuProfile {
 		return qemuProfile{
 			binary:         fmt.Sprintf("qemu-system-%s", arch),
 			machine:        "virt",
-			cpu:            "host",
+			cpu:            "max", // unlike "host", also accepted under TCG, which cross-arch guests use
 			biosCandidates: []string{
 				// no good generic candidates — let QEMU default if none exist
 			},
@@ -317,6 +442,8 @@ func loadConfig() (*Config, error) {
 	config := &Config{}
 
 	// Set defaults
+	config.Runtime = runtimeDocker
+	config.Podman.Rootless = false
 	config.VM.Architecture = "aarch64"
 	config.VM.Version = "42.20250803.3.0"
 	config.VM.Memory = "4096"
@@ -329,10 +456,31 @@ func loadConfig() (*Config, error) {
 	config.Network.HTTPPort = "80"
 	config.Network.KubernetesPort = "6443"
 	config.Network.K0sPort = "9443"
+	config.Network.KonnectivityPort = "8132"
+	config.Network.BindAddress = "127.0.0.1"
+	config.Network.Mode = networkModeUser
+	config.Network.Backend = networkBackendSlirp
+	config.Network.Bridge = "br0"
+	config.Network.Private.Enabled = false
+	config.Network.Private.Subnet = "10.10.0.0/24"
 	config.SSH.PublicKeyPath = "ssh_keys/coreos_rsa.pub"
 	config.SSH.PrivateKeyPath = "ssh_keys/coreos_rsa"
 	config.QEMU.EnableAcceleration = true
+	config.QEMU.Accelerator = acceleratorAuto
 	config.QEMU.CustomArgs = []string{}
+	config.DockerContext.Enabled = true
+	config.DockerContext.SetCurrent = false
+	config.DockerContext.Endpoint = "tcp"
+	config.DockerContext.NamePrefix = "container-host"
+	config.Kubernetes.Enabled = false
+	config.Kubernetes.K0sImage = "docker.io/k0sproject/k0s:v1.33.4-k0s.0"
+	config.Kubernetes.BootstrapTimeout = 600
+	config.DockerSocket.Enabled = false
+	config.DockerSocket.Path = defaultDockerSocketPath()
+	config.DockerSocket.Instance = 1
+	config.Console.Socket = true
+	config.Console.MaxLogSize = "10M"
+	config.Console.LogFiles = 3
 	config.Debug.PrintIgnitionConfig = true
 	config.Debug.Verbose = false
 
@@ -362,6 +510,92 @@ func loadConfig() (*Config, error) {
 
 	fmt.Printf("✅ Successfully parsed JSON configuration\n")
 
+	// slirp host forwards need a literal IPv4 address
+	if config.Network.BindAddress == "localhost" {
+		config.Network.BindAddress = "127.0.0.1"
+	}
+	if config.Network.BindAddress != "" && !isIPv4Address(config.Network.BindAddress) {
+		return nil, fmt.Errorf("invalid network.bindAddress %q (expected an IPv4 address)", config.Network.BindAddress)
+	}
+
+	if err := validateNetworkMode(config); err != nil {
+		return nil, err
+	}
+	if err := resolveNetworkBackend(config); err != nil {
+		return nil, err
+	}
+	if err := validateMounts(config); err != nil {
+		return nil, err
+	}
+	if err := validateDataDisks(config); err != nil {
+		return nil, err
+	}
+	if err := validateAccelerator(config); err != nil {
+		return nil, err
+	}
+	if config.QEMU.TPM && isWindowsHost() {
+		return nil, fmt.Errorf("qemu.tpm needs swtpm, which is not available on Windows hosts")
+	}
+	if config.VM.DiskSize != "" {
+		if _, err := parseSize(config.VM.DiskSize); err != nil {
+			return nil, fmt.Errorf("vm.diskSize: %v", err)
+		}
+	}
+	if _, err := parseSize(config.Console.MaxLogSize); err != nil {
+		return nil, fmt.Errorf("console.maxLogSize: %v", err)
+	}
+	if config.Console.LogFiles < 0 {
+		return nil, fmt.Errorf("console.logFiles must not be negative, got %d", config.Console.LogFiles)
+	}
+
+	switch config.Runtime {
+	case runtimeDocker, runtimePodman:
+	default:
+		return nil, fmt.Errorf("invalid runtime %q (expected docker or podman)", config.Runtime)
+	}
+
+	// Docker's ssh transport runs `docker system dial-stdio` in the guest, which Podman lacks.
+	if config.Runtime == runtimePodman && config.DockerContext.Endpoint == "ssh" {
+		fmt.Println("⚠️  dockerContext.endpoint \"ssh\" is not supported with the podman runtime, using \"tcp\"")
+		config.DockerContext.Endpoint = "tcp"
+	}
+
+	// On tap and bridge the API stays on guest loopback unless explicitly exposed, so Docker
+	// contexts must tunnel over SSH, which only works against dockerd.
+	if !containerAPIOverTCP(config) && config.DockerContext.Enabled && config.DockerContext.Endpoint == "tcp" {
+		if config.Runtime == runtimePodman {
+			fmt.Println("⚠️  Docker contexts need network.exposeContainerAPI with podman on tap/bridge, disabling them")
+			config.DockerContext.Enabled = false
+		} else {
+			fmt.Println("🔐 Container API is only served on guest loopback, using dockerContext.endpoint \"ssh\"")
+			config.DockerContext.Endpoint = "ssh"
+		}
+	}
+
+	// Behind user-mode NAT every node would register as 10.0.2.15, which breaks pod traffic
+	// and kubectl logs/exec on workers; nodes need their own addresses on the private network.
+	if config.Kubernetes.Enabled && config.VM.Instances > 1 && isUserNetworking(config) && !config.Network.Private.Enabled {
+		fmt.Println("☸️  kubernetes.enabled with multiple instances: enabling network.private so every node gets its own IP")
+		config.Network.Private.Enabled = true
+	}
+
+	if config.Network.Private.Enabled {
+		if _, err := privateAddress(config, config.VM.Instances-1); err != nil {
+			return nil, err
+		}
+		if config.Network.Private.McastAddress == "" {
+			if config.Network.Private.McastAddress, err = defaultMcastAddress(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch config.DockerContext.Endpoint {
+	case "tcp", "ssh":
+	default:
+		return nil, fmt.Errorf("invalid dockerContext.endpoint %q (expected tcp or ssh)", config.DockerContext.Endpoint)
+	}
+
 	// Set VM image if not specified in config
 	if config.VM.Image == "" {
 		config.VM.Image = fmt.Sprintf("images/coreos-%s-qemu.%s.qcow2", config.VM.Version, config.VM.Architecture)
@@ -379,6 +613,7 @@ func loadConfig() (*Config, error) {
 // printConfigurationValues displays the current configuration values
 func printConfigurationValues(config *Config) {
 	fmt.Println("\n📋 Current Configuration Values:")
+	fmt.Printf("  Runtime: %s\n", runtimeDisplayName(config))
 	fmt.Printf("  VM:\n")
 	fmt.Printf("    Architecture: %s\n", config.VM.Architecture)
 	fmt.Printf("    Version: %s\n", config.VM.Version)
@@ -388,6 +623,16 @@ func printConfigurationValues(config *Config) {
 	if config.VM.Image != "" {
 		fmt.Printf("    Image: %s\n", config.VM.Image)
 	}
+	if config.VM.DiskSize != "" {
+		fmt.Printf("    Disk Size: %s\n", config.VM.DiskSize)
+	}
+	for _, d := range config.VM.Disks {
+		fmt.Printf("    Disk %s: %s %s", d.Name, d.Size, d.Format)
+		if d.MountPoint != "" {
+			fmt.Printf(" (%s at %s)", d.Filesystem, d.MountPoint)
+		}
+		fmt.Println()
+	}
 	fmt.Printf("  Network:\n")
 	fmt.Printf("    SSH Port: %s\n", config.Network.SSHPort)
 	fmt.Printf("    VNC Port: %s\n", config.Network.VNCPort)
@@ -395,20 +640,512 @@ func printConfigurationValues(config *Config) {
 	fmt.Printf("    HTTP Port: %s\n", config.Network.HTTPPort)
 	fmt.Printf("    Kubernetes Port: %s\n", config.Network.KubernetesPort)
 	fmt.Printf("    K0s Port: %s\n", config.Network.K0sPort)
+	fmt.Printf("    Konnectivity Port: %s\n", config.Network.KonnectivityPort)
+	fmt.Printf("    Bind Address: %s\n", config.Network.BindAddress)
+	fmt.Printf("    Network Backend: %s\n", config.Network.Backend)
+	switch config.Network.Mode {
+	case networkModeTap:
+		fmt.Printf("    Network Mode: tap (%s)\n", strings.Join(config.Network.TapDevices, ", "))
+	case networkModeBridge:
+		fmt.Printf("    Network Mode: bridge (%s)\n", config.Network.Bridge)
+	}
+	if !isUserNetworking(config) {
+		fmt.Printf("    Expose Container API: %t\n", config.Network.ExposeContainerAPI)
+	}
+	if config.Network.Private.Enabled {
+		fmt.Printf("    Private Network: %s (multicast %s)\n", config.Network.Private.Subnet, config.Network.Private.McastAddress)
+	}
+	for _, fwd := range config.Network.Forwards {
+		protocol := fwd.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		fmt.Printf("    Forward %s: host %s → guest %d/%s\n", fwd.Name, fwd.HostPort, fwd.GuestPort, protocol)
+	}
 	fmt.Printf("  SSH:\n")
 	fmt.Printf("    Public Key Path: %s\n", config.SSH.PublicKeyPath)
 	fmt.Printf("    Private Key Path: %s\n", config.SSH.PrivateKeyPath)
 	fmt.Printf("  QEMU:\n")
 	fmt.Printf("    Acceleration Enabled: %t\n", config.QEMU.EnableAcceleration)
+	fmt.Printf("    Accelerator: %s\n", config.QEMU.Accelerator)
+	if config.QEMU.Machine != "" {
+		fmt.Printf("    Machine: %s\n", config.QEMU.Machine)
+	}
+	if config.QEMU.CPU != "" {
+		fmt.Printf("    CPU: %s\n", config.QEMU.CPU)
+	}
+	if config.QEMU.SecureBoot {
+		fmt.Printf("    Secure Boot: %t\n", config.QEMU.SecureBoot)
+	}
+	if config.QEMU.TPM {
+		fmt.Printf("    TPM: %t\n", config.QEMU.TPM)
+	}
 	if len(config.QEMU.CustomArgs) > 0 {
 		fmt.Printf("    Custom Args: %v\n", config.QEMU.CustomArgs)
 	}
+	fmt.Printf("  Docker Context:\n")
+	fmt.Printf("    Enabled: %t\n", config.DockerContext.Enabled)
+	if config.DockerContext.Enabled {
+		fmt.Printf("    Name Prefix: %s\n", config.DockerContext.NamePrefix)
+		fmt.Printf("    Endpoint: %s\n", config.DockerContext.Endpoint)
+		fmt.Printf("    Set Current: %t\n", config.DockerContext.SetCurrent)
+	}
+	fmt.Printf("  Kubernetes:\n")
+	fmt.Printf("    Enabled: %t\n", config.Kubernetes.Enabled)
+	if config.Kubernetes.Enabled {
+		fmt.Printf("    k0s Image: %s\n", config.Kubernetes.K0sImage)
+		fmt.Printf("    Bootstrap Timeout: %ds\n", config.Kubernetes.BootstrapTimeout)
+	}
+	if len(config.Mounts) > 0 {
+		fmt.Printf("  Mounts:\n")
+		for _, m := range config.Mounts {
+			mode := "rw"
+			if m.ReadOnly {
+				mode = "ro"
+			}
+			fmt.Printf("    %s → %s (%s)\n", m.HostPath, m.GuestPath, mode)
+		}
+	}
+	fmt.Printf("  Docker Socket:\n")
+	fmt.Printf("    Enabled: %t\n", config.DockerSocket.Enabled)
+	if config.DockerSocket.Enabled {
+		fmt.Printf("    Path: %s\n", config.DockerSocket.Path)
+		fmt.Printf("    Instance: %d\n", config.DockerSocket.Instance)
+	}
+	fmt.Printf("  Console:\n")
+	fmt.Printf("    Socket: %t\n", config.Console.Socket)
+	fmt.Printf("    Log Rotation: %s, %d file(s) kept\n", config.Console.MaxLogSize, config.Console.LogFiles)
 	fmt.Printf("  Debug:\n")
 	fmt.Printf("    Print Ignition Config: %t\n", config.Debug.PrintIgnitionConfig)
 	fmt.Printf("    Verbose: %t\n", config.Debug.Verbose)
 }
 
+// usage lists the subcommands; running without one is the same as `up`.
+const usage = `Usage: container-host [command] [arguments]
+
+Commands:
+  up [-arch ARCH] [-version VERSION]   Provision and start the instances (default)
+  destroy [-force]                     Remove Docker contexts, Ignition files and instance state
+  doctor                               Check host prerequisites
+  socket                               Relay a host unix socket to an instance's Docker API
+  kubeconfig [-merge] [-output PATH]   Fetch the k0s admin kubeconfig
+  port add|rm|ls <instance> [spec]     Change port forwards of a running instance
+  snapshot save|ls|restore|rm <instance> [name]
+                                       Manage internal snapshots
+  suspend [instance]                   Save running instances to disk for a fast resume
+  logs [-f] [instance]                 Print an instance's serial console log
+  console <instance>                   Attach to an instance's serial console
+  help                                 Show this help
+`
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "up":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		case "destroy":
+			runDestroy(os.Args[2:])
+			return
+		case "socket":
+			runSocket(os.Args[2:])
+			return
+		case "kubeconfig":
+			runKubeconfig(os.Args[2:])
+			return
+		case "port":
+			runPort(os.Args[2:])
+			return
+		case "snapshot":
+			runSnapshot(os.Args[2:])
+			return
+		case "suspend":
+			runSuspend(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor()
+			return
+		case "logs":
+			runLogs(os.Args[2:])
+			return
+		case "console":
+			runConsole(os.Args[2:])
+			return
+		case "help":
+			fmt.Print(usage)
+			return
+		default:
+			// Flags such as -arch belong to up; anything else is a mistyped command
+			if !strings.HasPrefix(os.Args[1], "-") {
+				fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+				os.Exit(2)
+			}
+		}
+	}
+	runUp()
+}
+
+// runDestroy removes host-side artifacts created for the configured instances.
+func runDestroy(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	fs := flag.NewFlagSet("destroy", flag.ExitOnError)
+	force := fs.Bool("force", false, "Do not ask for confirmation")
+	fs.Parse(args)
+
+	// Removing disks under a running QEMU would leave it writing to deleted files
+	if running := runningInstances(config); len(running) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: instance(s) %s still running; stop them before destroying\n", strings.Join(running, ", "))
+		os.Exit(1)
+	}
+	if _, err := os.Stat(stateRoot); err == nil && !*force {
+		fmt.Printf("This permanently deletes all instance disks, data disks, snapshots and suspended state in %s/.\n", stateRoot)
+		fmt.Print("Continue? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Aborted")
+			os.Exit(1)
+		}
+	}
+
+	removed, err := removeDockerContexts(config.DockerContext.NamePrefix)
+	for _, name := range removed {
+		fmt.Printf("🗑️  Removed Docker context %s\n", name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing Docker contexts: %v\n", err)
+		os.Exit(1)
+	}
+
+	configFiles, _ := filepath.Glob("configs/ignition-instance-*.json")
+	for _, file := range configFiles {
+		if err := os.Remove(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		fmt.Printf("🗑️  Removed %s\n", file)
+	}
+
+	if _, err := os.Stat(stateRoot); err == nil {
+		if err := os.RemoveAll(stateRoot); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing instance state: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("🗑️  Removed instance state in %s/\n", stateRoot)
+	}
+}
+
+// runningInstances lists the numbers of instances with state whose QMP socket answers.
+func runningInstances(config *Config) []string {
+	count := config.VM.Instances
+	dirs, _ := filepath.Glob(filepath.Join(stateRoot, "instance-*"))
+	for _, dir := range dirs {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(dir), "instance-%d", &n); err == nil && n > count {
+			count = n
+		}
+	}
+	var running []string
+	for i := 0; i < count; i++ {
+		if qmp, err := dialQMP(i); err == nil {
+			qmp.Close()
+			running = append(running, strconv.Itoa(i+1))
+		}
+	}
+	return running
+}
+
+// runSocket relays a host unix socket to an already running instance's Docker endpoint.
+func runSocket(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	fs := flag.NewFlagSet("socket", flag.ExitOnError)
+	socketPath := fs.String("path", config.DockerSocket.Path, "Host unix socket to listen on")
+	fs.Parse(args)
+
+	config.DockerSocket.Path = *socketPath
+	if fs.NArg() > 0 {
+		instance, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			log.Fatalf("invalid instance number %q: %v", fs.Arg(0), err)
+		}
+		config.DockerSocket.Instance = instance
+	}
+
+	target, err := dockerSocketTarget(config)
+	if err != nil {
+		log.Fatalf("failed to resolve Docker endpoint: %v", err)
+	}
+	listener, err := listenDockerSocket(config.DockerSocket.Path)
+	if err != nil {
+		log.Fatalf("failed to create Docker socket: %v", err)
+	}
+	defer os.Remove(config.DockerSocket.Path)
+
+	via := ""
+	if !containerAPIOverTCP(config) {
+		via = " over SSH"
+	}
+	fmt.Printf("🔌 Relaying unix://%s → tcp://%s%s (instance %d)\n", config.DockerSocket.Path, target, via, config.DockerSocket.Instance)
+	fmt.Printf("  Host Access: export DOCKER_HOST=unix://%s\n", config.DockerSocket.Path)
+	if err := serveDockerSocket(listener, config); err != nil {
+		log.Fatalf("Docker socket relay stopped: %v", err)
+	}
+}
+
+// runKubeconfig fetches the admin kubeconfig from the k0s controller on instance 1 and
+// rewrites it to use the host-forwarded Kubernetes API port.
+func runKubeconfig(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	fs := flag.NewFlagSet("kubeconfig", flag.ExitOnError)
+	merge := fs.Bool("merge", false, "Merge into ~/.kube/config (or the first $KUBECONFIG entry)")
+	output := fs.String("output", "kubeconfig", "Path to write the rewritten kubeconfig")
+	container := fs.String("container", k0sControllerContainer, "Name of the k0s controller container on instance 1")
+	fs.Parse(args)
+
+	kubernetesPort, err := instancePort(config, portKubernetes, 0)
+	if err != nil {
+		log.Fatalf("failed to calculate Kubernetes port: %v", err)
+	}
+
+	admin, err := fetchAdminKubeconfig(config, *container)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching kubeconfig: %v\n", err)
+		os.Exit(1)
+	}
+	address, err := instanceAddress(config, 0)
+	if err != nil {
+		log.Fatalf("failed to resolve instance 1 address: %v", err)
+	}
+	kc := rewriteKubeconfig(admin, fmt.Sprintf("https://%s", net.JoinHostPort(address, kubernetesPort)))
+
+	if err := writeKubeconfig(*output, kc); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing kubeconfig: %v\n", err)
+		os.Exit(1)
+	}
+	absOutput, _ := filepath.Abs(*output)
+	fmt.Printf("☸️  Wrote kubeconfig for context %q to %s\n", kubeconfigName, absOutput)
+
+	if *merge {
+		target, err := defaultKubeconfigPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error locating kubeconfig: %v\n", err)
+			os.Exit(1)
+		}
+		if err := mergeKubeconfig(target, *output, kc); err != nil {
+			fmt.Fprintf(os.Stderr, "Error merging kubeconfig: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("☸️  Merged context %q into %s (select it with: kubectl config use-context %s)\n", kubeconfigName, target, kubeconfigName)
+		return
+	}
+	fmt.Printf("  Use it with: export KUBECONFIG=%s\n", absOutput)
+}
+
+// runPort adds, removes or lists port forwards on an instance without restarting it.
+func runPort(args []string) {
+	usage := "usage: container-host port add|rm|ls <instance> [[bind:]host:guest[/tcp|/udp]]"
+	if len(args) < 2 {
+		log.Fatal(usage)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+	instance, err := strconv.Atoi(args[1])
+	if err != nil || instance < 1 || instance > config.VM.Instances {
+		log.Fatalf("invalid instance %q (expected 1-%d)", args[1], config.VM.Instances)
+	}
+
+	if args[0] != "ls" && !isUserNetworking(config) {
+		log.Fatalf("port forwards only apply to network.mode %q; instances in %q mode are reachable directly", networkModeUser, config.Network.Mode)
+	}
+	if args[0] != "ls" && config.Network.Backend != networkBackendSlirp {
+		log.Fatalf("changing forwards of a running instance requires network.backend %q; edit network.forwards and restart instead", networkBackendSlirp)
+	}
+
+	switch args[0] {
+	case "ls":
+		err = listForwards(config, instance-1)
+	case "add", "rm":
+		if len(args) != 3 {
+			log.Fatal(usage)
+		}
+		var fwd runtimeForward
+		fwd, err = parseForwardSpec(args[2], args[0] == "add")
+		if err != nil {
+			break
+		}
+		if fwd.BindAddress == "" {
+			fwd.BindAddress = config.Network.BindAddress
+		}
+		if args[0] == "add" {
+			err = addRuntimeForward(config, instance-1, fwd)
+		} else {
+			err = removeRuntimeForward(instance-1, fwd)
+		}
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runDoctor checks host prerequisites and exits non-zero when something is broken.
+func runDoctor() {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("❌ Configuration: %v\n", err)
+		fmt.Println("   Fix: correct container-host.config.json and run doctor again")
+		os.Exit(1)
+	}
+	fmt.Println("=== container-host doctor ===")
+	fmt.Println("✅ Configuration: valid")
+
+	if failures := runDoctorChecks(config); failures > 0 {
+		fmt.Printf("\n%d problem(s) found\n", failures)
+		os.Exit(1)
+	}
+	fmt.Println("\nEverything looks good")
+}
+
+// runSuspend saves the state of running instances to disk so the next `up` resumes them.
+func runSuspend(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	instances := []int{}
+	if len(args) > 0 {
+		instance, err := strconv.Atoi(args[0])
+		if err != nil || instance < 1 || instance > config.VM.Instances {
+			log.Fatalf("invalid instance %q (expected 1-%d)", args[0], config.VM.Instances)
+		}
+		instances = append(instances, instance-1)
+	} else {
+		for i := 0; i < config.VM.Instances; i++ {
+			instances = append(instances, i)
+		}
+	}
+
+	if err := checkMountsMigratable(config, "suspend"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, i := range instances {
+		if err := suspendInstance(i); err != nil {
+			fmt.Fprintf(os.Stderr, "Error suspending instance %d: %v\n", i+1, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runLogs prints an instance's serial console log, optionally following it.
+func runLogs(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := fs.Bool("f", false, "Keep printing new console output")
+	fs.Parse(args)
+
+	instance := 1
+	if fs.NArg() > 0 {
+		instance, err = strconv.Atoi(fs.Arg(0))
+		if err != nil || instance < 1 || instance > config.VM.Instances {
+			log.Fatalf("invalid instance %q (expected 1-%d)", fs.Arg(0), config.VM.Instances)
+		}
+	}
+
+	if err := printSerialLog(instance-1, *follow); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runConsole attaches the terminal to a running instance's serial console.
+func runConsole(args []string) {
+	if len(args) != 1 {
+		log.Fatal("usage: container-host console <instance>")
+	}
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+	instance, err := strconv.Atoi(args[0])
+	if err != nil || instance < 1 || instance > config.VM.Instances {
+		log.Fatalf("invalid instance %q (expected 1-%d)", args[0], config.VM.Instances)
+	}
+
+	if err := attachConsole(config, instance-1); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runSnapshot saves, lists, restores or deletes internal snapshots of an instance.
+func runSnapshot(args []string) {
+	usage := "usage: container-host snapshot save|ls|restore|rm <instance> [name]"
+	if len(args) < 2 {
+		log.Fatal(usage)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+	instance, err := strconv.Atoi(args[1])
+	if err != nil || instance < 1 || instance > config.VM.Instances {
+		log.Fatalf("invalid instance %q (expected 1-%d)", args[1], config.VM.Instances)
+	}
+
+	if args[0] == "ls" {
+		err = listSnapshots(config, instance-1)
+	} else {
+		if len(args) != 3 {
+			log.Fatal(usage)
+		}
+		name := args[2]
+		if !snapshotNamePattern.MatchString(name) {
+			log.Fatalf("invalid snapshot name %q (use letters, digits, '.', '-' or '_')", name)
+		}
+		switch args[0] {
+		case "save":
+			err = saveSnapshot(config, instance-1, name)
+		case "restore":
+			err = restoreSnapshot(config, instance-1, name)
+		case "rm":
+			err = deleteSnapshot(config, instance-1, name)
+		default:
+			log.Fatal(usage)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runUp provisions and launches the configured VM instances.
+func runUp() {
 	// Load configuration first
 	config, err := loadConfig()
 	if err != nil {
@@ -447,14 +1184,17 @@ func main() {
 	// Use configuration values
 	memory := config.VM.Memory
 	cpus := config.VM.CPUs
-	sshPort := config.Network.SSHPort
-	vncPort := config.Network.VNCPort
-	dockerPort := config.Network.DockerPort
-	httpPort := config.Network.HTTPPort
-	kubernetesPort := config.Network.KubernetesPort
-	k0sPort := config.Network.K0sPort
 	sshPublicKeyPath := config.SSH.PublicKeyPath
 
+	warnExposedBindAddresses(config)
+
+	// Assign host ports up front so conflicts are reported before QEMU starts
+	allPorts, err := allocateInstancePorts(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error allocating host ports: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Check if VM image exists
 	if _, err := os.Stat(vmImage); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "Error: VM image '%s' not found\n", vmImage)
@@ -480,7 +1220,7 @@ func main() {
 	}
 
 	// Create Ignition configuration
-	ignitionConfig, err := createIgnitionConfig(sshPublicKey, dockerPort)
+	ignitionConfig, err := createIgnitionConfig(config, 0, sshPublicKey)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating ignition config: %v\n", err)
 		os.Exit(1)
@@ -512,6 +1252,21 @@ func main() {
 	fmt.Printf("Ignition config written to %s (%d bytes)\n", configFile, len(ignitionConfig))
 	fmt.Println("✓ Configuration file ready for fw_cfg")
 
+	// Ignition only runs on first boot, so refuse before anything starts if an existing
+	// instance would silently miss a config change
+	instanceIgnitionConfigs := make([]string, config.VM.Instances)
+	for i := range instanceIgnitionConfigs {
+		instanceIgnitionConfigs[i], err = createIgnitionConfig(config, i, sshPublicKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating ignition config for instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		if err := checkIgnitionUnchanged(i, instanceIgnitionConfigs[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Print connection details before starting VMs
 	fmt.Println("=== Fedora CoreOS VM Connection Details ===")
 	fmt.Printf("VM Image: %s\n", vmImage)
@@ -520,24 +1275,60 @@ func main() {
 	fmt.Printf("Number of instances: %d\n", config.VM.Instances)
 
 	for i := 0; i < config.VM.Instances; i++ {
-		instanceSSHPort, _ := calculatePort(sshPort, i)
-		instanceVNCPort, _ := calculatePort(vncPort, i)
-		instanceHTTPPort, _ := calculatePort(httpPort, i)
-		instanceDockerPort, _ := calculatePort(dockerPort, i)
-		instanceKubernetesPort, _ := calculatePort(kubernetesPort, i)
-		instanceK0sPort, _ := calculatePort(k0sPort, i)
+		// On tap and bridge the guest IP is only known once it has booted
+		address := "<guest-ip>"
+		if isUserNetworking(config) {
+			address = hostConnectAddress(config)
+		}
+		instanceSSHPort := allPorts[i][portSSH]
+		instanceVNCPort := allPorts[i][portVNC]
+		instanceHTTPPort := allPorts[i][portHTTP]
+		instanceDockerPort := allPorts[i][portDocker]
+		instanceKubernetesPort := allPorts[i][portKubernetes]
+		instanceK0sPort := allPorts[i][portK0s]
 
 		fmt.Printf("Instance %d:\n", i+1)
-		fmt.Printf("  SSH Port: %s (connect with: ssh -p %s core@localhost)\n", instanceSSHPort, instanceSSHPort)
-		fmt.Printf("  VNC Port: %s (connect with VNC viewer to localhost:%s)\n", instanceVNCPort, instanceVNCPort)
-		fmt.Printf("  HTTP Port: %s (web services accessible at localhost:%s)\n", instanceHTTPPort, instanceHTTPPort)
-		fmt.Printf("  Docker Port: %s (Docker API accessible at localhost:%s)\n", instanceDockerPort, instanceDockerPort)
-		fmt.Printf("  Kubernetes API Port: %s (kubectl API at localhost:%s)\n", instanceKubernetesPort, instanceKubernetesPort)
-		fmt.Printf("  K0s API Port: %s (K0s API at localhost:%s)\n", instanceK0sPort, instanceK0sPort)
-		fmt.Printf("  Host Access: export DOCKER_HOST=tcp://localhost:%s\n", instanceDockerPort)
+		fmt.Printf("  SSH Port: %s (connect with: ssh -p %s core@%s)\n", instanceSSHPort, instanceSSHPort, address)
+		fmt.Printf("  VNC Port: %s (connect with VNC viewer to %s:%s)\n", instanceVNCPort, hostConnectAddress(config), instanceVNCPort)
+		fmt.Printf("  HTTP Port: %s (web services accessible at %s:%s)\n", instanceHTTPPort, address, instanceHTTPPort)
+		if containerAPIOverTCP(config) {
+			fmt.Printf("  Docker Port: %s (%s API accessible at %s:%s)\n", instanceDockerPort, runtimeDisplayName(config), address, instanceDockerPort)
+		} else {
+			fmt.Printf("  Docker Port: %s (%s API on guest loopback only, reach it over SSH)\n", instanceDockerPort, runtimeDisplayName(config))
+		}
+		fmt.Printf("  Kubernetes API Port: %s (kubectl API at %s:%s)\n", instanceKubernetesPort, address, instanceKubernetesPort)
+		fmt.Printf("  K0s API Port: %s (K0s API at %s:%s)\n", instanceK0sPort, address, instanceK0sPort)
+		if config.Kubernetes.Enabled {
+			fmt.Printf("  Konnectivity Port: %s\n", allPorts[i][portKonnectivity])
+		}
+		for _, spec := range configuredPorts(config) {
+			if port, ok := allPorts[i][spec.name]; ok && spec.custom {
+				fmt.Printf("  Forward %s: %s:%s → guest %d/%s\n", spec.label, address, port, spec.guestPort, spec.protocol)
+			}
+		}
+		printRuntimeConnectionHints(config, i, address, instanceSSHPort, instanceDockerPort)
+
+		// Tap and bridge contexts are written once the guest address is discovered
+		if config.DockerContext.Enabled && isUserNetworking(config) {
+			if err := configureDockerContext(config, i, instanceSSHPort, instanceDockerPort); err != nil {
+				fmt.Fprintf(os.Stderr, "Error configuring Docker context for instance %d: %v\n", i+1, err)
+				os.Exit(1)
+			}
+		}
 	}
 
-	fmt.Println("Docker Engine: Will be enabled and started on first boot")
+	fmt.Printf("%s Engine: Will be enabled and started on first boot\n", runtimeDisplayName(config))
+
+	if config.DockerSocket.Enabled {
+		stopRelay, err := startDockerSocketRelay(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting Docker socket relay: %v\n", err)
+			os.Exit(1)
+		}
+		defer stopRelay()
+		fmt.Printf("Docker Socket: unix://%s → instance %d\n", config.DockerSocket.Path, config.DockerSocket.Instance)
+		fmt.Printf("  Host Access: export DOCKER_HOST=unix://%s\n", config.DockerSocket.Path)
+	}
 	fmt.Println("==========================================")
 	fmt.Printf("Starting %d VM instance(s)...\n", config.VM.Instances)
 
@@ -547,8 +1338,12 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Error getting absolute path: %v\n", err)
 		os.Exit(1)
 	}
+	if err := checkDiskSize(absImagePath, config.VM.DiskSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	prof := profileForArch(*arch)
+	prof := profileForArch(*arch, config)
 
 	// Ensure the QEMU binary exists in PATH
 	qemuPath, err := exec.LookPath(prof.binary)
@@ -557,106 +1352,160 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Probe before launching so missing accelerator access is explained, not a QEMU error
+	accelerator, accelReason, err := chooseAccelerator(config, *arch, qemuPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	printAcceleratorChoice(accelerator, accelReason)
+
 	// Optional: choose a matching firmware/BIOS if present
-	biosPath := findFirstExisting(prof.biosCandidates...)
-	biosArgs := []string{}
-	if biosPath != "" {
-		biosArgs = []string{"-bios", biosPath}
+	fw, err := selectFirmware(config, prof)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
+	fmt.Printf("🔧 Firmware: %s\n", fw.describe())
 
 	// Start multiple VM instances
+	var foregroundCmd *exec.Cmd
 	for i := 0; i < config.VM.Instances; i++ {
-		// Calculate ports for this instance
-		instanceSSHPort, err := calculatePort(sshPort, i)
+		// Ports for this instance were assigned by allocateInstancePorts
+		instanceVNCDisplay, err := vncDisplay(allPorts[i][portVNC])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error calculating SSH port for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error calculating VNC display for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
-		instanceVNCPort, err := calculatePort(vncPort, i)
+
+		// Write instance-specific ignition config
+		instanceIgnitionConfig := instanceIgnitionConfigs[i]
+		instanceConfigFile := fmt.Sprintf("configs/ignition-instance-%d.json", i+1)
+		err = ioutil.WriteFile(instanceConfigFile, []byte(instanceIgnitionConfig), 0644)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error calculating VNC port for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error writing ignition config file for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
-		instanceHTTPPort, err := calculatePort(httpPort, i)
+
+		// Each instance boots from its own overlay so instances don't share a writable disk
+		instanceDisk, err := ensureInstanceDisk(absImagePath, config.VM.DiskSize, i)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error calculating HTTP port for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error preparing disk for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
-		instanceDockerPort, err := calculatePort(dockerPort, i)
+
+		dataDiskArgs, err := ensureDataDisks(config, i)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error calculating Docker port for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error preparing data disks for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
-		instanceKubernetesPort, err := calculatePort(kubernetesPort, i)
+
+		instanceState, err := loadInstanceState(i)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error calculating Kubernetes port for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error loading state for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
-		instanceK0sPort, err := calculatePort(k0sPort, i)
+		netdev, err := primaryNetdev(config, i, instanceForwards(config, allPorts[i], instanceState))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error calculating K0s port for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error setting up networking for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
 
-		// Create ignition config for this instance with the correct Docker port
-		instanceIgnitionConfig, err := createIgnitionConfig(sshPublicKey, instanceDockerPort)
+		// A stale address from the previous boot must not be used before rediscovery
+		if instanceState.GuestIP != "" {
+			instanceState.GuestIP = ""
+			if err := saveInstanceState(i, instanceState); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving state for instance %d: %v\n", i+1, err)
+				os.Exit(1)
+			}
+		}
+
+		mac, err := instanceMAC(i, 0)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating ignition config for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error assigning MAC address for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
 
-		// Write instance-specific ignition config
-		instanceConfigFile := fmt.Sprintf("configs/ignition-instance-%d.json", i+1)
-		err = ioutil.WriteFile(instanceConfigFile, []byte(instanceIgnitionConfig), 0644)
+		agentArgs, err := guestAgentArgs(i)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing ignition config file for instance %d: %v\n", i+1, err)
+			fmt.Fprintf(os.Stderr, "Error resolving guest agent socket for instance %d: %v\n", i+1, err)
 			os.Exit(1)
 		}
 
+		qmpSocket, err := instanceSocketPath(i, "qmp.sock")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving QMP socket for instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+
+		machine := prof.machine
+		if fw.smm {
+			machine += ",smm=on"
+		}
+
 		args := []string{
-			"-M", prof.machine,
+			"-M", machine,
 			"-smp", cpus,
 			"-m", memory,
-			"-drive", fmt.Sprintf("file=%s,format=qcow2,if=virtio", absImagePath),
-			"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp::%s-:22,hostfwd=tcp::%s-:%s,hostfwd=tcp::%s-:80,hostfwd=tcp::%s-:6443,hostfwd=tcp::%s-:9443", instanceSSHPort, instanceDockerPort, instanceDockerPort, instanceHTTPPort, instanceKubernetesPort, instanceK0sPort),
-			"-device", "virtio-net-pci,netdev=net0",
+			"-drive", fmt.Sprintf("file=%s,format=qcow2,if=virtio", instanceDisk),
+			"-netdev", netdev,
+			"-device", fmt.Sprintf("virtio-net-pci,netdev=net0,mac=%s", mac),
 			"-device", "virtio-rng-pci",
-			"-vnc", fmt.Sprintf(":%s", instanceVNCPort[len(instanceVNCPort)-1:]),
+			"-vnc", fmt.Sprintf("%s:%d", config.Network.BindAddress, instanceVNCDisplay),
 			"-fw_cfg", fmt.Sprintf("name=opt/com.coreos/config,file=%s", instanceConfigFile),
-			"-global", "kvm-pit.lost_tick_policy=discard",
 			"-rtc", "base=utc,driftfix=slew",
+			"-qmp", fmt.Sprintf("unix:%s,server=on,wait=off", qmpSocket),
 		}
 
-		// For multiple instances, only the first one gets stdio, others get null
-		if i == 0 {
-			args = append(args, "-serial", "stdio")
-		} else {
-			args = append(args, "-serial", "null")
+		// Every serial console is logged; only the first instance is also on stdio
+		consoleArgs, err := serialArgs(config, i)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting up serial console for instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		args = append(args, consoleArgs...)
+		if i > 0 {
 			args = append(args, "-daemonize") // Run additional instances in background
 		}
 
-		// Add hardware acceleration if enabled in config
-		if config.QEMU.EnableAcceleration {
-			switch runtime.GOOS {
-			case "darwin":
-				args = append(args, "-accel", "hvf")
-			case "linux":
-				args = append(args, "-accel", "kvm")
-			case "windows":
-				args = append(args, "-accel", "whpx")
-				// Enable Hyper-V enlightenments for better Windows guest performance
-				args = append(args, "-cpu", "host,hv_relaxed,hv_spinlocks=0x1fff,hv_vapic,hv_time")
-			}
+		args = append(args, acceleratorArgs(config, prof, *arch, accelerator)...)
+
+		for _, global := range prof.globals {
+			args = append(args, "-global", global)
 		}
 
-		// Only set -cpu if we have a profile-specific value and not on Windows with acceleration
-		if prof.cpu != "" && !(runtime.GOOS == "windows" && config.QEMU.EnableAcceleration) {
-			args = append([]string{"-cpu", prof.cpu}, args...)
+		args = append(args, dataDiskArgs...)
+		args = append(args, agentArgs...)
+		sharedDirArgs, err := mountArgs(config, i)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error sharing host directories with instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		args = append(args, sharedDirArgs...)
+		if config.Network.Private.Enabled {
+			privateArgs, err := privateNetworkArgs(config, i)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error configuring private network for instance %d: %v\n", i+1, err)
+				os.Exit(1)
+			}
+			args = append(args, privateArgs...)
 		}
 
-		// Prepend optional BIOS args at the end so it overrides defaults if present
+		// Firmware: pflash CODE plus this instance's VARS store, or -bios as a fallback
+		biosArgs, err := firmwareArgs(fw, i)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error preparing firmware for instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
 		args = append(args, biosArgs...)
 
+		tpmDeviceArgs, err := tpmArgs(config, *arch, i)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting TPM for instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		args = append(args, tpmDeviceArgs...)
+
 		// Add custom QEMU arguments from configuration
 		if len(config.QEMU.CustomArgs) > 0 {
 			args = append(args, config.QEMU.CustomArgs...)
@@ -665,6 +1514,14 @@ func main() {
 			}
 		}
 
+		// Resume from a suspend when the machine is configured exactly as before
+		resumeArgs, resuming, err := prepareResume(i, qemuPath, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking suspended state of instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		args = append(args, resumeArgs...)
+
 		// Compose and run
 		qemuCmd := exec.Command(qemuPath, args...)
 
@@ -682,19 +1539,43 @@ func main() {
 
 		fmt.Printf("Starting instance %d: %s %s\n", i+1, qemuPath, strings.Join(args, " "))
 
+		if err := qemuCmd.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting VM instance %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		if resuming {
+			go finishResume(i)
+		}
 		if i == 0 {
-			// Start first instance in foreground (blocking)
-			if err := qemuCmd.Run(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error starting VM instance %d: %v\n", i+1, err)
-				os.Exit(1)
-			}
+			// The first instance stays attached to the terminal; wait for it once all are started
+			foregroundCmd = qemuCmd
 		} else {
-			// Start additional instances in background
-			if err := qemuCmd.Start(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error starting VM instance %d: %v\n", i+1, err)
-				os.Exit(1)
-			}
 			fmt.Printf("Instance %d started in background (PID: %d)\n", i+1, qemuCmd.Process.Pid)
 		}
 	}
+
+	if !isUserNetworking(config) {
+		for i := 0; i < config.VM.Instances; i++ {
+			go announceGuestAddress(config, i)
+		}
+	}
+
+	go watchSerialLogs(config)
+
+	if config.Kubernetes.Enabled {
+		go func() {
+			if err := bootstrapKubernetes(config); err != nil {
+				fmt.Fprintf(os.Stderr, "Error bootstrapping Kubernetes cluster: %v\n", err)
+				return
+			}
+			fmt.Printf("☸️  k0s cluster ready: 1 controller, %d worker(s)\n", config.VM.Instances-1)
+		}()
+	}
+
+	if foregroundCmd != nil {
+		if err := foregroundCmd.Wait(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running VM instance 1: %v\n", err)
+			os.Exit(1)
+		}
+	}
 }
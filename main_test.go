@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfileForArch(t *testing.T) {
+	x86Globals := []string{"kvm-pit.lost_tick_policy=discard"}
+	tests := []struct {
+		name    string
+		arch    string
+		machine string // qemu.machine override
+		cpu     string // qemu.cpu override
+		want    qemuProfile
+	}{
+		{
+			name: "aarch64",
+			arch: "aarch64",
+			want: qemuProfile{binary: "qemu-system-aarch64", machine: "virt", cpu: "max"},
+		},
+		{
+			name: "x86_64",
+			arch: "x86_64",
+			want: qemuProfile{binary: "qemu-system-x86_64", machine: "q35", cpu: "max", globals: x86Globals},
+		},
+		{
+			name: "amd64 alias",
+			arch: "amd64",
+			want: qemuProfile{binary: "qemu-system-x86_64", machine: "q35", cpu: "max", globals: x86Globals},
+		},
+		{
+			name: "fallback",
+			arch: "riscv64",
+			want: qemuProfile{binary: "qemu-system-riscv64", machine: "virt", cpu: "max"},
+		},
+		{
+			name:    "aarch64 overrides",
+			arch:    "aarch64",
+			machine: "virt-9.0",
+			cpu:     "cortex-a72",
+			want:    qemuProfile{binary: "qemu-system-aarch64", machine: "virt-9.0", cpu: "cortex-a72"},
+		},
+		{
+			name:    "x86_64 overrides",
+			arch:    "x86_64",
+			machine: "pc",
+			cpu:     "host",
+			want:    qemuProfile{binary: "qemu-system-x86_64", machine: "pc", cpu: "host", globals: x86Globals},
+		},
+		{
+			name: "fallback cpu override",
+			arch: "riscv64",
+			cpu:  "rv64",
+			want: qemuProfile{binary: "qemu-system-riscv64", machine: "virt", cpu: "rv64"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.QEMU.Machine = tt.machine
+			config.QEMU.CPU = tt.cpu
+			got := profileForArch(tt.arch, config)
+
+			if got.binary != tt.want.binary {
+				t.Errorf("binary = %q, want %q", got.binary, tt.want.binary)
+			}
+			if got.machine != tt.want.machine {
+				t.Errorf("machine = %q, want %q", got.machine, tt.want.machine)
+			}
+			if got.cpu != tt.want.cpu {
+				t.Errorf("cpu = %q, want %q", got.cpu, tt.want.cpu)
+			}
+			if !reflect.DeepEqual(got.globals, tt.want.globals) {
+				t.Errorf("globals = %v, want %v", got.globals, tt.want.globals)
+			}
+		})
+	}
+}
+
+func TestKVMPitGlobalOnlyOnX86(t *testing.T) {
+	for _, arch := range []string{"aarch64", "riscv64", "ppc64le"} {
+		for _, global := range profileForArch(arch, &Config{}).globals {
+			if global == "kvm-pit.lost_tick_policy=discard" {
+				t.Errorf("%s profile has the x86-only kvm-pit global", arch)
+			}
+		}
+	}
+}
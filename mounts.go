@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Values describing how host directories are shared with the guest.
+const (
+	shareVirtiofs = "virtiofs"
+	share9p       = "9p"
+)
+
+// virtiofsdCandidates are the usual install locations of virtiofsd, which distributions
+// often keep out of PATH.
+var virtiofsdCandidates = []string{
+	"/usr/libexec/virtiofsd",
+	"/usr/lib/qemu/virtiofsd",
+	"/usr/lib/virtiofsd",
+}
+
+// fcosVarSymlinks are top-level Fedora CoreOS paths that are symlinks into /var. systemd
+// mount units need the resolved path.
+var fcosVarSymlinks = map[string]string{
+	"/home":      "/var/home",
+	"/mnt":       "/var/mnt",
+	"/opt":       "/var/opt",
+	"/srv":       "/var/srv",
+	"/root":      "/var/roothome",
+	"/usr/local": "/var/usrlocal",
+}
+
+// findVirtiofsd returns the virtiofsd binary, or "" when it is not installed.
+func findVirtiofsd() string {
+	if path, err := exec.LookPath("virtiofsd"); err == nil {
+		return path
+	}
+	return findFirstExisting(virtiofsdCandidates...)
+}
+
+// mountShareType picks virtiofs when virtiofsd is available (Linux only) and 9p otherwise.
+func mountShareType() string {
+	if runtime.GOOS == "linux" && findVirtiofsd() != "" {
+		return shareVirtiofs
+	}
+	return share9p
+}
+
+// checkMountsMigratable fails an operation that saves the VM state when mounts are
+// configured: virtiofs devices cannot be migrated and a mounted 9p share blocks
+// migration, so QEMU would reject savevm and migrate with a less helpful error.
+func checkMountsMigratable(config *Config, operation string) error {
+	if len(config.Mounts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s needs to save the VM state, which QEMU cannot do while host directories are shared over %s (mounts)", operation, mountShareType())
+}
+
+// validateMounts resolves host paths and defaults guest paths to mirror them, so
+// `docker run -v $PWD:...` works unchanged inside the VM.
+func validateMounts(config *Config) error {
+	if len(config.Mounts) > 0 && runtime.GOOS == "windows" {
+		return fmt.Errorf("mounts are not supported on Windows hosts")
+	}
+	for i := range config.Mounts {
+		m := &config.Mounts[i]
+		if m.HostPath == "" {
+			return fmt.Errorf("mounts[%d]: hostPath is required", i)
+		}
+		hostPath, err := filepath.Abs(m.HostPath)
+		if err != nil {
+			return fmt.Errorf("mounts[%d]: %v", i, err)
+		}
+		if info, err := os.Stat(hostPath); err != nil || !info.IsDir() {
+			return fmt.Errorf("mounts[%d]: %s is not a directory", i, hostPath)
+		}
+		m.HostPath = hostPath
+		if m.GuestPath == "" {
+			m.GuestPath = filepath.ToSlash(hostPath)
+		}
+		if !strings.HasPrefix(m.GuestPath, "/") || m.GuestPath == "/" {
+			return fmt.Errorf("mounts[%d]: guestPath %q must be an absolute path other than /", i, m.GuestPath)
+		}
+		if strings.ContainsAny(m.GuestPath, " \t'\"\\") {
+			return fmt.Errorf("mounts[%d]: guestPath %q must not contain whitespace, quotes or backslashes", i, m.GuestPath)
+		}
+	}
+	return nil
+}
+
+// mountTag returns the tag identifying a share between QEMU and the guest.
+func mountTag(index int) string {
+	return fmt.Sprintf("hostshare%d", index)
+}
+
+// guestMountPoint maps a guest path to where it really lives on Fedora CoreOS.
+func guestMountPoint(guestPath string) string {
+	guestPath = filepath.ToSlash(filepath.Clean(guestPath))
+	for link, target := range fcosVarSymlinks {
+		if guestPath == link || strings.HasPrefix(guestPath, link+"/") {
+			return target + strings.TrimPrefix(guestPath, link)
+		}
+	}
+	return guestPath
+}
+
+// systemdEscapePath implements `systemd-escape --path`, which mount unit names must match.
+func systemdEscapePath(path string) string {
+	path = strings.Trim(path, "/")
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c == '.' && i == 0:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
+
+// mountUnits returns the systemd units that mount every share at boot. Fedora CoreOS keeps
+// / immutable, so mount points outside /var (such as /Users for macOS paths) are created
+// by briefly lifting the immutable attribute.
+func mountUnits(config *Config) []SystemdUnit {
+	if len(config.Mounts) == 0 {
+		return nil
+	}
+	shareType := mountShareType()
+
+	var mkdirs []string
+	var units []SystemdUnit
+	for i, m := range config.Mounts {
+		where := guestMountPoint(m.GuestPath)
+		mkdirs = append(mkdirs, where)
+
+		options := []string{}
+		if shareType == share9p {
+			options = append(options, "trans=virtio", "version=9p2000.L", "msize=524288")
+		}
+		if m.ReadOnly {
+			options = append(options, "ro")
+		}
+		optionsLine := ""
+		if len(options) > 0 {
+			optionsLine = "Options=" + strings.Join(options, ",") + "\n"
+		}
+
+		units = append(units, SystemdUnit{
+			Name:    systemdEscapePath(where) + ".mount",
+			Enabled: true,
+			Contents: fmt.Sprintf(`[Unit]
+Description=Host directory %s
+Requires=container-host-mountpoints.service
+After=container-host-mountpoints.service
+
+[Mount]
+What=%s
+Where=%s
+Type=%s
+%s
+[Install]
+WantedBy=multi-user.target
+`, m.HostPath, mountTag(i), where, shareType, optionsLine),
+		})
+	}
+
+	mountpoints := fmt.Sprintf(`[Unit]
+Description=Create mount points for host directories
+DefaultDependencies=no
+After=local-fs.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=%s
+
+[Install]
+WantedBy=multi-user.target
+`, mkdirCommand(mkdirs))
+
+	return append([]SystemdUnit{{Name: "container-host-mountpoints.service", Enabled: true, Contents: mountpoints}}, units...)
+}
+
+// mkdirCommand returns a systemd ExecStart command creating dirs in the guest, lifting the
+// immutable attribute of / if needed.
+func mkdirCommand(dirs []string) string {
+	return fmt.Sprintf(`/bin/sh -c 'mkdir -p %[1]s 2>/dev/null || { chattr -i /; mkdir -p %[1]s; rc=$?; chattr +i /; exit $rc; }'`, strings.Join(dirs, " "))
+}
+
+// mountArgs starts the file servers for an instance's shares and returns the QEMU
+// arguments attaching them. virtiofs also needs guest RAM to be shareable.
+func mountArgs(config *Config, instanceIndex int) ([]string, error) {
+	if len(config.Mounts) == 0 {
+		return nil, nil
+	}
+
+	var args []string
+	if mountShareType() == share9p {
+		for i, m := range config.Mounts {
+			virtfs := fmt.Sprintf("local,path=%s,mount_tag=%s,security_model=none,id=%s", m.HostPath, mountTag(i), mountTag(i))
+			if m.ReadOnly {
+				virtfs += ",readonly=on"
+			}
+			args = append(args, "-virtfs", virtfs)
+		}
+		return args, nil
+	}
+
+	args = append(args,
+		"-object", fmt.Sprintf("memory-backend-memfd,id=mem,size=%sM,share=on", config.VM.Memory),
+		"-numa", "node,memdev=mem",
+	)
+	for i, m := range config.Mounts {
+		socketPath, err := startVirtiofsd(instanceIndex, i, m)
+		if err != nil {
+			return nil, fmt.Errorf("share %s: %v", m.HostPath, err)
+		}
+		args = append(args,
+			"-chardev", fmt.Sprintf("socket,id=fs%d,path=%s", i, socketPath),
+			"-device", fmt.Sprintf("vhost-user-fs-pci,chardev=fs%d,tag=%s", i, mountTag(i)),
+		)
+	}
+	return args, nil
+}
+
+// startVirtiofsd launches virtiofsd for one share of an instance. It exits on its own
+// when QEMU disconnects.
+func startVirtiofsd(instanceIndex, shareIndex int, m Mount) (string, error) {
+	socketPath, err := instanceSocketPath(instanceIndex, fmt.Sprintf("virtiofs-%d.sock", shareIndex))
+	if err != nil {
+		return "", err
+	}
+	_ = os.Remove(socketPath)
+
+	args := []string{"--socket-path", socketPath, "--shared-dir", m.HostPath, "--cache", "auto"}
+	if m.ReadOnly {
+		args = append(args, "--readonly")
+	}
+	if os.Geteuid() != 0 {
+		// The default namespace sandbox needs root
+		args = append(args, "--sandbox", "none")
+	}
+	cmd := exec.Command(findVirtiofsd(), args...)
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start virtiofsd: %v", err)
+	}
+	go cmd.Wait()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if _, err := os.Stat(socketPath); err == nil {
+			return socketPath, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("virtiofsd did not create %s", socketPath)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Values of network.backend, the user-mode networking implementation.
+const (
+	networkBackendSlirp = "slirp"
+	networkBackendPasst = "passt"
+)
+
+// passtGuestAddress, passtGatewayAddress and passtPrefixLength mirror slirp's default
+// layout so the guest reaches the host at slirpHostAddress with either backend.
+const (
+	passtGuestAddress   = slirpGuestAddress
+	passtGatewayAddress = slirpHostAddress
+	passtPrefixLength   = "24"
+)
+
+// resolveNetworkBackend validates network.backend and falls back to slirp when passt
+// cannot be used on this host.
+func resolveNetworkBackend(config *Config) error {
+	config.Network.RequestedBackend = config.Network.Backend
+	switch config.Network.Backend {
+	case networkBackendSlirp:
+		return nil
+	case networkBackendPasst:
+	default:
+		return fmt.Errorf("network.backend must be %q or %q, got %q", networkBackendSlirp, networkBackendPasst, config.Network.Backend)
+	}
+	if runtime.GOOS != "linux" {
+		fmt.Println("⚠️  network.backend \"passt\" is only available on Linux, using slirp")
+		config.Network.Backend = networkBackendSlirp
+	} else if _, err := exec.LookPath("passt"); err != nil {
+		fmt.Println("⚠️  passt not found in PATH, using slirp")
+		config.Network.Backend = networkBackendSlirp
+	}
+	return nil
+}
+
+// instanceForwards lists the host forwards of an instance, from its assigned ports plus
+// the runtime forwards recorded in state, independent of the networking backend.
+func instanceForwards(config *Config, ports map[string]string, state *instanceState) []runtimeForward {
+	var forwards []runtimeForward
+	for _, spec := range configuredPorts(config) {
+		port, ok := ports[spec.name]
+		if !spec.forwarded || !ok {
+			continue
+		}
+		guestPort := port
+		if spec.guestPort != 0 {
+			guestPort = fmt.Sprint(spec.guestPort)
+		}
+		forwards = append(forwards, runtimeForward{
+			Protocol:    spec.protocol,
+			BindAddress: spec.bindAddress,
+			HostPort:    port,
+			GuestPort:   guestPort,
+		})
+	}
+	if state != nil {
+		forwards = append(forwards, state.Forwards...)
+	}
+	return forwards
+}
+
+// slirpNetdev returns the -netdev value for QEMU's built-in slirp stack.
+func slirpNetdev(forwards []runtimeForward) string {
+	parts := []string{"user,id=net0"}
+	for _, fwd := range forwards {
+		parts = append(parts, "hostfwd="+fwd.hostfwd())
+	}
+	return strings.Join(parts, ",")
+}
+
+// passtArgs returns the passt command line serving an instance's NIC on socketPath.
+func passtArgs(socketPath, pidPath string, forwards []runtimeForward) []string {
+	args := []string{
+		"--one-off", // exit together with QEMU
+		"--socket", socketPath,
+		"--pid", pidPath,
+		"--address", passtGuestAddress,
+		"--netmask", passtPrefixLength,
+		"--gateway", passtGatewayAddress,
+	}
+	for _, fwd := range forwards {
+		flag := "-t"
+		if fwd.Protocol == "udp" {
+			flag = "-u"
+		}
+		spec := fwd.HostPort + ":" + fwd.GuestPort
+		if fwd.BindAddress != "" {
+			spec = fwd.BindAddress + "/" + spec
+		}
+		args = append(args, flag, spec)
+	}
+	return args
+}
+
+// startPasst launches passt for an instance and returns the -netdev value connecting
+// QEMU to it. passt daemonizes, so it outlives the launcher like background instances do.
+func startPasst(instanceIndex int, forwards []runtimeForward) (string, error) {
+	socketPath, err := instanceSocketPath(instanceIndex, "passt.sock")
+	if err != nil {
+		return "", err
+	}
+	pidPath, err := instanceSocketPath(instanceIndex, "passt.pid")
+	if err != nil {
+		return "", err
+	}
+	_ = os.Remove(socketPath)
+
+	out, err := exec.Command("passt", passtArgs(socketPath, pidPath, forwards)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("passt failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("passt did not create %s", socketPath)
+		}
+	}
+	return fmt.Sprintf("stream,id=net0,server=off,addr.type=unix,addr.path=%s", socketPath), nil
+}
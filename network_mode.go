@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Values of network.mode.
+const (
+	networkModeUser   = "user"
+	networkModeTap    = "tap"
+	networkModeBridge = "bridge"
+)
+
+// guestIPTimeout bounds how long to wait for a tap or bridge guest to obtain an address.
+const guestIPTimeout = 5 * time.Minute
+
+// dhcpLeaseFiles are where common DHCP servers on a Linux host record leases.
+var dhcpLeaseFiles = []string{
+	"/var/lib/misc/dnsmasq.leases",
+	"/var/lib/dnsmasq/dnsmasq.leases",
+	"/var/lib/libvirt/dnsmasq/*.leases",
+	"/var/lib/libvirt/dnsmasq/*.status",
+}
+
+// isUserNetworking reports whether instances sit behind QEMU user-mode NAT, reached
+// through host port forwards, rather than directly on a host network.
+func isUserNetworking(config *Config) bool {
+	return config.Network.Mode == networkModeUser
+}
+
+// validateNetworkMode checks network.mode and the settings it depends on.
+func validateNetworkMode(config *Config) error {
+	switch config.Network.Mode {
+	case networkModeUser:
+		return nil
+	case networkModeTap, networkModeBridge:
+	default:
+		return fmt.Errorf("network.mode must be %q, %q or %q, got %q", networkModeUser, networkModeTap, networkModeBridge, config.Network.Mode)
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("network.mode %q is only supported on Linux", config.Network.Mode)
+	}
+	if config.Network.Mode == networkModeTap && len(config.Network.TapDevices) < config.VM.Instances {
+		return fmt.Errorf("network.tapDevices lists %d device(s) but %d instance(s) are configured", len(config.Network.TapDevices), config.VM.Instances)
+	}
+	if config.Network.Mode == networkModeBridge && config.Network.Bridge == "" {
+		return fmt.Errorf("network.bridge is required when network.mode is %q", networkModeBridge)
+	}
+	return nil
+}
+
+// primaryNetdev returns the -netdev value of an instance's first NIC, starting passt if it
+// is the user-mode backend. Forwards only apply to user-mode networking; on tap and bridge
+// the guest is addressed directly.
+func primaryNetdev(config *Config, instanceIndex int, forwards []runtimeForward) (string, error) {
+	switch config.Network.Mode {
+	case networkModeTap:
+		return fmt.Sprintf("tap,id=net0,ifname=%s,script=no,downscript=no", config.Network.TapDevices[instanceIndex]), nil
+	case networkModeBridge:
+		netdev := fmt.Sprintf("bridge,id=net0,br=%s", config.Network.Bridge)
+		if config.Network.BridgeHelper != "" {
+			netdev += ",helper=" + config.Network.BridgeHelper
+		}
+		return netdev, nil
+	}
+	if config.Network.Backend == networkBackendPasst {
+		return startPasst(instanceIndex, forwards)
+	}
+	return slirpNetdev(forwards), nil
+}
+
+// guestAgentArgs returns the QEMU arguments exposing the guest agent channel on a host socket.
+func guestAgentArgs(instanceIndex int) ([]string, error) {
+	socket, err := instanceSocketPath(instanceIndex, "qga.sock")
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"-chardev", fmt.Sprintf("socket,id=qga0,path=%s,server=on,wait=off", socket),
+		"-device", "virtio-serial",
+		"-device", "virtserialport,chardev=qga0,name=org.qemu.guest_agent.0",
+	}, nil
+}
+
+// instanceAddress returns the address host-side clients dial to reach an instance: the
+// forward bind address in user mode, or the discovered guest IP on tap and bridge.
+func instanceAddress(config *Config, instanceIndex int) (string, error) {
+	if isUserNetworking(config) {
+		return hostConnectAddress(config), nil
+	}
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return "", err
+	}
+	if state.GuestIP == "" {
+		return "", fmt.Errorf("instance %d has no known IP address yet; start it first", instanceIndex+1)
+	}
+	return state.GuestIP, nil
+}
+
+// discoverGuestIP waits until the guest's primary NIC has an IPv4 address, asking the
+// guest agent first and falling back to the host's DHCP leases, and records it in state.
+func discoverGuestIP(config *Config, instanceIndex int, timeout time.Duration) (string, error) {
+	mac, err := instanceMAC(instanceIndex, 0)
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ip := guestAgentAddress(instanceIndex, mac)
+		if ip == "" {
+			ip = dhcpLeaseAddress(mac)
+		}
+		if ip != "" {
+			state, err := loadInstanceState(instanceIndex)
+			if err != nil {
+				return "", err
+			}
+			state.GuestIP = ip
+			return ip, saveInstanceState(instanceIndex, state)
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("no IP address found for %s via the guest agent or DHCP leases after %s", mac, timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// announceGuestAddress discovers a tap or bridge guest's address, prints how to reach it
+// and writes its Docker context, which could not be written before boot.
+func announceGuestAddress(config *Config, instanceIndex int) {
+	ip, err := discoverGuestIP(config, instanceIndex, guestIPTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error discovering instance %d address: %v\n", instanceIndex+1, err)
+		return
+	}
+	sshPort, _ := instancePort(config, portSSH, instanceIndex)
+	dockerPort, _ := instancePort(config, portDocker, instanceIndex)
+	fmt.Printf("🌐 Instance %d is at %s (connect with: ssh -p %s core@%s)\n", instanceIndex+1, ip, sshPort, ip)
+	if config.DockerContext.Enabled {
+		if err := configureDockerContext(config, instanceIndex, sshPort, dockerPort); err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring Docker context for instance %d: %v\n", instanceIndex+1, err)
+		}
+	}
+}
+
+// guestAgentAddress asks the QEMU guest agent for the IPv4 address of the NIC with mac.
+func guestAgentAddress(instanceIndex int, mac string) string {
+	agent, err := dialGuestAgent(instanceIndex)
+	if err != nil {
+		return ""
+	}
+	defer agent.Close()
+
+	ret, err := agent.execute("guest-network-get-interfaces", nil)
+	if err != nil {
+		return ""
+	}
+	var interfaces []struct {
+		HardwareAddress string `json:"hardware-address"`
+		IPAddresses     []struct {
+			Type    string `json:"ip-address-type"`
+			Address string `json:"ip-address"`
+		} `json:"ip-addresses"`
+	}
+	if err := json.Unmarshal(ret, &interfaces); err != nil {
+		return ""
+	}
+	for _, iface := range interfaces {
+		if !strings.EqualFold(iface.HardwareAddress, mac) {
+			continue
+		}
+		for _, addr := range iface.IPAddresses {
+			if addr.Type == "ipv4" {
+				return addr.Address
+			}
+		}
+	}
+	return ""
+}
+
+// dhcpLeaseAddress searches dnsmasq and libvirt lease files for the address leased to mac.
+func dhcpLeaseAddress(mac string) string {
+	for _, pattern := range dhcpLeaseFiles {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			var ip string
+			if strings.HasSuffix(path, ".status") {
+				ip = libvirtStatusAddress(path, mac)
+			} else {
+				ip = dnsmasqLeaseAddress(path, mac)
+			}
+			if ip != "" {
+				return ip
+			}
+		}
+	}
+	return ""
+}
+
+// dnsmasqLeaseAddress parses "<expiry> <mac> <ip> <hostname> <client-id>" lease lines.
+func dnsmasqLeaseAddress(path, mac string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	var ip string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && strings.EqualFold(fields[1], mac) && !strings.Contains(fields[2], ":") {
+			ip = fields[2] // later lines are newer leases
+		}
+	}
+	return ip
+}
+
+// libvirtStatusAddress parses the JSON lease status files written by libvirt's dnsmasq helper.
+func libvirtStatusAddress(path, mac string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var leases []struct {
+		IPAddress  string `json:"ip-address"`
+		MACAddress string `json:"mac-address"`
+	}
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return ""
+	}
+	var ip string
+	for _, lease := range leases {
+		if strings.EqualFold(lease.MACAddress, mac) && !strings.Contains(lease.IPAddress, ":") {
+			ip = lease.IPAddress
+		}
+	}
+	return ip
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// runtimeForward is a forward added with `port add`, persisted so it is re-applied on boot.
+type runtimeForward struct {
+	Protocol    string `json:"protocol"`
+	BindAddress string `json:"bindAddress,omitempty"`
+	HostPort    string `json:"hostPort"`
+	GuestPort   string `json:"guestPort,omitempty"`
+}
+
+// hostfwd returns the slirp rule for the forward.
+func (f runtimeForward) hostfwd() string {
+	return fmt.Sprintf("%s:%s:%s-:%s", f.Protocol, f.BindAddress, f.HostPort, f.GuestPort)
+}
+
+// hostKey identifies the host side of the forward, which is what hostfwd_remove matches.
+func (f runtimeForward) hostKey() string {
+	return fmt.Sprintf("%s:%s:%s", f.Protocol, f.BindAddress, f.HostPort)
+}
+
+func (f runtimeForward) String() string {
+	host := f.HostPort
+	if f.BindAddress != "" {
+		host = f.BindAddress + ":" + host
+	}
+	if f.GuestPort == "" {
+		return fmt.Sprintf("%s/%s", host, f.Protocol)
+	}
+	return fmt.Sprintf("%s → guest %s/%s", host, f.GuestPort, f.Protocol)
+}
+
+// parseForwardSpec parses "[bind:]host[:guest][/tcp|/udp]". The guest port is required
+// unless requireGuest is false (as for `port rm`).
+func parseForwardSpec(spec string, requireGuest bool) (runtimeForward, error) {
+	fwd := runtimeForward{Protocol: "tcp"}
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		fwd.Protocol = spec[i+1:]
+		spec = spec[:i]
+	}
+	if fwd.Protocol != "tcp" && fwd.Protocol != "udp" {
+		return fwd, fmt.Errorf("invalid protocol %q (expected tcp or udp)", fwd.Protocol)
+	}
+
+	parts := strings.Split(spec, ":")
+	switch {
+	case len(parts) == 3:
+		fwd.BindAddress, fwd.HostPort, fwd.GuestPort = parts[0], parts[1], parts[2]
+	case len(parts) == 2 && (requireGuest || isNumeric(parts[0])):
+		fwd.HostPort, fwd.GuestPort = parts[0], parts[1]
+	case len(parts) == 2:
+		fwd.BindAddress, fwd.HostPort = parts[0], parts[1]
+	case len(parts) == 1 && !requireGuest:
+		fwd.HostPort = parts[0]
+	default:
+		if requireGuest {
+			return fwd, fmt.Errorf("invalid forward %q (expected [bind:]host:guest[/tcp|/udp])", spec)
+		}
+		return fwd, fmt.Errorf("invalid forward %q (expected [bind:]host[/tcp|/udp])", spec)
+	}
+
+	if fwd.BindAddress != "" && !isIPv4Address(fwd.BindAddress) {
+		return fwd, fmt.Errorf("invalid bind address %q in %q (expected an IPv4 address)", fwd.BindAddress, spec)
+	}
+
+	ports := []string{fwd.HostPort}
+	if fwd.GuestPort != "" {
+		ports = append(ports, fwd.GuestPort)
+	}
+	for _, p := range ports {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return fwd, fmt.Errorf("invalid port %q in %q", p, spec)
+		}
+	}
+	return fwd, nil
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// addRuntimeForward installs a forward on a running instance and records it in state.
+// Host ports assigned to any instance are refused, since the next start would collide.
+func addRuntimeForward(config *Config, instanceIndex int, fwd runtimeForward) error {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return err
+	}
+	for _, existing := range state.Forwards {
+		if existing.hostKey() == fwd.hostKey() {
+			return fmt.Errorf("instance %d already forwards %s", instanceIndex+1, existing)
+		}
+	}
+	claimed, err := claimedHostPorts(config)
+	if err != nil {
+		return err
+	}
+	if owner, ok := claimed[fwd.Protocol+"/"+fwd.HostPort]; ok {
+		return fmt.Errorf("host port %s/%s is already used by %s", fwd.HostPort, fwd.Protocol, owner)
+	}
+	if err := checkPortAvailable(fwd.Protocol, fwd.BindAddress, fwd.HostPort); err != nil {
+		return err
+	}
+
+	if qmp, err := dialQMP(instanceIndex); err == nil {
+		defer qmp.Close()
+		out, err := qmp.humanMonitorCommand("hostfwd_add net0 " + fwd.hostfwd())
+		if err != nil {
+			return err
+		}
+		if out = strings.TrimSpace(out); out != "" {
+			return fmt.Errorf("hostfwd_add failed: %s", out)
+		}
+		fmt.Printf("🔀 Added forward %s on running instance %d\n", fwd, instanceIndex+1)
+	} else {
+		fmt.Printf("⚠️  Instance %d is not running; the forward will be applied on next start\n", instanceIndex+1)
+	}
+
+	state.Forwards = append(state.Forwards, fwd)
+	return saveInstanceState(instanceIndex, state)
+}
+
+// removeRuntimeForward removes a forward added with `port add` from a running instance
+// and from state.
+func removeRuntimeForward(instanceIndex int, fwd runtimeForward) error {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return err
+	}
+
+	// Only forwards added with `port add` may go; configured ones would silently come
+	// back on the next start and the instance would lose SSH or Docker until then.
+	kept := state.Forwards[:0]
+	found := false
+	for _, existing := range state.Forwards {
+		if existing.hostKey() == fwd.hostKey() {
+			found = true
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	if !found {
+		return fmt.Errorf("%s is not a runtime forward of instance %d; configured forwards can only be changed in container-host.config.json", fwd, instanceIndex+1)
+	}
+	state.Forwards = kept
+
+	if qmp, err := dialQMP(instanceIndex); err == nil {
+		defer qmp.Close()
+		out, err := qmp.humanMonitorCommand("hostfwd_remove net0 " + fwd.hostKey())
+		if err != nil {
+			return err
+		}
+		if out = strings.TrimSpace(out); out != "" && !strings.Contains(out, "removed") {
+			return fmt.Errorf("hostfwd_remove failed: %s", out)
+		}
+		fmt.Printf("🔀 Removed forward %s from running instance %d\n", fwd, instanceIndex+1)
+	}
+	return saveInstanceState(instanceIndex, state)
+}
+
+// listForwards prints the configured and runtime forwards of an instance, plus QEMU's live view.
+func listForwards(config *Config, instanceIndex int) error {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return err
+	}
+
+	if !isUserNetworking(config) {
+		fmt.Printf("Instance %d uses network.mode %q and is reachable directly; no forwards apply\n", instanceIndex+1, config.Network.Mode)
+		return nil
+	}
+
+	fmt.Printf("Instance %d forwards:\n", instanceIndex+1)
+	for _, fwd := range instanceForwards(config, state.Ports, nil) {
+		fmt.Printf("  %s (config)\n", fwd)
+	}
+	for _, fwd := range state.Forwards {
+		fmt.Printf("  %s (runtime)\n", fwd)
+	}
+	if config.Network.Backend != networkBackendSlirp {
+		return nil
+	}
+
+	qmp, err := dialQMP(instanceIndex)
+	if err != nil {
+		fmt.Println("Instance is not running; live forwards unavailable")
+		return nil
+	}
+	defer qmp.Close()
+	out, err := qmp.humanMonitorCommand("info usernet")
+	if err != nil {
+		return err
+	}
+	fmt.Println("Live slirp state:")
+	fmt.Print(out)
+	return nil
+}
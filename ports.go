@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// autoPort requests a free host port instead of a fixed base port.
+const autoPort = "auto"
+
+// vncBasePort is the TCP port of VNC display :0.
+const vncBasePort = 5900
+
+// Names of the host ports recorded per instance.
+const (
+	portSSH          = "ssh"
+	portVNC          = "vnc"
+	portDocker       = "docker"
+	portHTTP         = "http"
+	portKubernetes   = "kubernetes"
+	portK0s          = "k0s"
+	portKonnectivity = "konnectivity"
+)
+
+// portSpec describes one host port assigned per instance, and the guest port it forwards to.
+type portSpec struct {
+	name        string
+	label       string
+	base        string
+	guestPort   int // 0 forwards to the same port number as the host side
+	forwarded   bool
+	custom      bool // declared in network.forwards
+	protocol    string
+	bindAddress string
+	offset      int
+	instances   []int // 1-based instance numbers; empty means every instance
+}
+
+// appliesTo reports whether the port is assigned for the zero-based instance index.
+func (spec portSpec) appliesTo(instanceIndex int) bool {
+	if len(spec.instances) == 0 {
+		return true
+	}
+	for _, n := range spec.instances {
+		if n == instanceIndex+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredPorts lists the host ports instances need, in display order: the built-in
+// service ports followed by network.forwards.
+func configuredPorts(config *Config) []portSpec {
+	specs := []portSpec{
+		{name: portSSH, label: "SSH", base: config.Network.SSHPort, guestPort: 22, forwarded: true},
+		{name: portVNC, label: "VNC", base: config.Network.VNCPort},
+		{name: portHTTP, label: "HTTP", base: config.Network.HTTPPort, guestPort: 80, forwarded: true},
+		{name: portDocker, label: "Docker", base: config.Network.DockerPort, guestPort: guestAPIPort, forwarded: true},
+		{name: portKubernetes, label: "Kubernetes", base: config.Network.KubernetesPort, guestPort: 6443, forwarded: true},
+		{name: portK0s, label: "K0s", base: config.Network.K0sPort, guestPort: 9443, forwarded: true},
+	}
+	if config.Kubernetes.Enabled {
+		specs = append(specs, portSpec{name: portKonnectivity, label: "Konnectivity", base: config.Network.KonnectivityPort, guestPort: 8132, forwarded: true})
+	}
+	for i := range specs {
+		specs[i].protocol = "tcp"
+		specs[i].offset = 1
+		specs[i].bindAddress = config.Network.BindAddress
+	}
+
+	for _, fwd := range config.Network.Forwards {
+		spec := portSpec{
+			name:        fwd.Name,
+			label:       fwd.Name,
+			base:        fwd.HostPort,
+			guestPort:   fwd.GuestPort,
+			forwarded:   true,
+			custom:      true,
+			protocol:    fwd.Protocol,
+			bindAddress: fwd.BindAddress,
+			offset:      1,
+			instances:   fwd.Instances,
+		}
+		if spec.protocol == "" {
+			spec.protocol = "tcp"
+		}
+		if spec.bindAddress == "" {
+			spec.bindAddress = config.Network.BindAddress
+		}
+		if spec.name == "" {
+			spec.name = fmt.Sprintf("%s-%d", spec.protocol, fwd.GuestPort)
+			spec.label = spec.name
+		}
+		if fwd.Offset != nil {
+			spec.offset = *fwd.Offset
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// validateForwards checks network.forwards entries for invalid or duplicate settings.
+func validateForwards(config *Config) error {
+	names := map[string]bool{}
+	for _, spec := range configuredPorts(config) {
+		if names[spec.name] {
+			return fmt.Errorf("duplicate port forward name %q", spec.name)
+		}
+		names[spec.name] = true
+	}
+	for _, fwd := range config.Network.Forwards {
+		if fwd.GuestPort < 1 || fwd.GuestPort > 65535 {
+			return fmt.Errorf("forward %q: invalid guestPort %d", fwd.Name, fwd.GuestPort)
+		}
+		if fwd.BindAddress != "" && !isIPv4Address(fwd.BindAddress) {
+			return fmt.Errorf("forward %q: invalid bindAddress %q (expected an IPv4 address)", fwd.Name, fwd.BindAddress)
+		}
+		if fwd.Protocol != "" && fwd.Protocol != "tcp" && fwd.Protocol != "udp" {
+			return fmt.Errorf("forward %q: invalid protocol %q (expected tcp or udp)", fwd.Name, fwd.Protocol)
+		}
+		if fwd.Offset != nil && *fwd.Offset < 0 {
+			return fmt.Errorf("forward %q: offset must not be negative", fwd.Name)
+		}
+		for _, n := range fwd.Instances {
+			if n < 1 || n > config.VM.Instances {
+				return fmt.Errorf("forward %q: instance %d is out of range (1-%d)", fwd.Name, n, config.VM.Instances)
+			}
+		}
+	}
+	return nil
+}
+
+// hostPortFor returns the fixed host port of a spec for an instance.
+func (spec portSpec) hostPortFor(instanceIndex int) (string, error) {
+	return calculatePort(spec.base, instanceIndex*spec.offset)
+}
+
+// guestPortFor returns the port a service listens on inside the guest, which tap and
+// bridge clients connect to directly.
+func (spec portSpec) guestPortFor() (string, error) {
+	if spec.guestPort != 0 {
+		return strconv.Itoa(spec.guestPort), nil
+	}
+	if spec.base == autoPort {
+		return "", fmt.Errorf("%s port cannot be %q on tap and bridge networks", spec.label, autoPort)
+	}
+	return spec.base, nil
+}
+
+// checkPortRanges rejects fixed host ports that would be assigned twice across instances.
+func checkPortRanges(config *Config) error {
+	if err := validateForwards(config); err != nil {
+		return err
+	}
+
+	owners := map[string]string{}
+	for _, spec := range configuredPorts(config) {
+		if spec.base == autoPort || (spec.forwarded && !isUserNetworking(config)) {
+			continue
+		}
+		start, err := strconv.Atoi(spec.base)
+		if err != nil || start < 1 || start > 65535 {
+			return fmt.Errorf("invalid %s port %q (expected 1-65535 or %q)", spec.label, spec.base, autoPort)
+		}
+		if spec.name == portVNC && start < vncBasePort {
+			return fmt.Errorf("VNC port %d must be %d or higher", start, vncBasePort)
+		}
+		for i := 0; i < config.VM.Instances; i++ {
+			if !spec.appliesTo(i) {
+				continue
+			}
+			port, _ := spec.hostPortFor(i)
+			if p, _ := strconv.Atoi(port); p > 65535 {
+				return fmt.Errorf("%s port %s for instance %d exceeds 65535", spec.label, port, i+1)
+			}
+			key := spec.protocol + "/" + port
+			owner := fmt.Sprintf("%s port of instance %d", spec.label, i+1)
+			if previous, ok := owners[key]; ok {
+				return fmt.Errorf("%s overlaps %s (both use %s)", owner, previous, key)
+			}
+			owners[key] = owner
+		}
+	}
+	return nil
+}
+
+// checkPortAvailable reports whether the host can bind the port for the protocol on bindAddress.
+func checkPortAvailable(protocol, bindAddress, port string) error {
+	var err error
+	address := net.JoinHostPort(bindAddress, port)
+	if protocol == "udp" {
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket("udp", address); err == nil {
+			return conn.Close()
+		}
+	} else {
+		var listener net.Listener
+		if listener, err = net.Listen("tcp", address); err == nil {
+			return listener.Close()
+		}
+	}
+	if errors.Is(err, syscall.EACCES) {
+		return fmt.Errorf("port %s/%s requires elevated privileges", port, protocol)
+	}
+	return fmt.Errorf("port %s/%s is already in use", port, protocol)
+}
+
+// findFreePort returns a free port, scanning upward from start (or asking the OS when start is 0).
+// Ports already claimed in taken are skipped.
+func findFreePort(protocol, bindAddress string, start int, taken map[string]string) (string, error) {
+	if start == 0 {
+		for attempt := 0; attempt < 20; attempt++ {
+			var port string
+			if protocol == "udp" {
+				conn, err := net.ListenPacket("udp", net.JoinHostPort(bindAddress, "0"))
+				if err != nil {
+					return "", fmt.Errorf("failed to find a free port: %v", err)
+				}
+				port = strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+				conn.Close()
+			} else {
+				listener, err := net.Listen("tcp", net.JoinHostPort(bindAddress, "0"))
+				if err != nil {
+					return "", fmt.Errorf("failed to find a free port: %v", err)
+				}
+				port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+				listener.Close()
+			}
+			if taken[protocol+"/"+port] == "" {
+				return port, nil
+			}
+		}
+		return "", fmt.Errorf("failed to find a free port")
+	}
+	for p := start; p <= 65535; p++ {
+		port := strconv.Itoa(p)
+		if taken[protocol+"/"+port] == "" && checkPortAvailable(protocol, bindAddress, port) == nil {
+			return port, nil
+		}
+	}
+	return "", fmt.Errorf("no free port at or above %d", start)
+}
+
+// allocateInstancePorts assigns host ports for every instance, verifying fixed ports are
+// bindable and resolving "auto" ports (reusing the previous assignment when still free).
+// On tap and bridge networks forwarded services record their guest port instead.
+// The result is recorded in each instance's state.
+func allocateInstancePorts(config *Config) ([]map[string]string, error) {
+	if err := checkPortRanges(config); err != nil {
+		return nil, err
+	}
+
+	// Runtime forwards are re-applied at boot, so their host ports are claimed first. Fixed
+	// ports of every instance follow before any "auto" port is resolved, so an automatic
+	// pick can't land on a port a later instance is configured to use.
+	taken := map[string]string{}
+	for i := 0; i < config.VM.Instances; i++ {
+		state, err := loadInstanceState(i)
+		if err != nil {
+			return nil, err
+		}
+		for _, fwd := range state.Forwards {
+			taken[fwd.Protocol+"/"+fwd.HostPort] = fmt.Sprintf("runtime forward %s of instance %d", fwd, i+1)
+		}
+	}
+	for i := 0; i < config.VM.Instances; i++ {
+		for _, spec := range configuredPorts(config) {
+			if !spec.appliesTo(i) || spec.base == autoPort || (spec.forwarded && !isUserNetworking(config)) {
+				continue
+			}
+			port, err := spec.hostPortFor(i)
+			if err != nil {
+				return nil, err
+			}
+			key := spec.protocol + "/" + port
+			if owner, ok := taken[key]; ok {
+				return nil, fmt.Errorf("instance %d %s port %s is already used by %s", i+1, spec.label, port, owner)
+			}
+			taken[key] = fmt.Sprintf("the %s port of instance %d", spec.label, i+1)
+		}
+	}
+
+	all := make([]map[string]string, config.VM.Instances)
+	for i := 0; i < config.VM.Instances; i++ {
+		state, err := loadInstanceState(i)
+		if err != nil {
+			return nil, err
+		}
+
+		ports := map[string]string{}
+		for _, spec := range configuredPorts(config) {
+			if !spec.appliesTo(i) {
+				continue
+			}
+			var port string
+			if spec.forwarded && !isUserNetworking(config) {
+				// Nothing is bound on the host; record the guest port clients dial instead
+				if port, err = spec.guestPortFor(); err != nil {
+					return nil, err
+				}
+				ports[spec.name] = port
+				continue
+			}
+			if spec.base == autoPort {
+				previous := state.Ports[spec.name]
+				if previous != "" && taken[spec.protocol+"/"+previous] == "" && checkPortAvailable(spec.protocol, spec.bindAddress, previous) == nil {
+					port = previous
+				} else {
+					start := 0
+					if spec.name == portVNC {
+						start = vncBasePort
+					}
+					if port, err = findFreePort(spec.protocol, spec.bindAddress, start, taken); err != nil {
+						return nil, fmt.Errorf("instance %d %s port: %v", i+1, spec.label, err)
+					}
+				}
+			} else {
+				if port, err = spec.hostPortFor(i); err != nil {
+					return nil, err
+				}
+				if err := checkPortAvailable(spec.protocol, spec.bindAddress, port); err != nil {
+					return nil, fmt.Errorf("instance %d %s port: %v (use %q to pick one automatically)", i+1, spec.label, err, autoPort)
+				}
+			}
+			taken[spec.protocol+"/"+port] = fmt.Sprintf("the %s port of instance %d", spec.label, i+1)
+			ports[spec.name] = port
+		}
+
+		state.Ports = ports
+		if err := saveInstanceState(i, state); err != nil {
+			return nil, err
+		}
+		all[i] = ports
+	}
+	return all, nil
+}
+
+// claimedHostPorts maps "protocol/port" to the instance port or runtime forward holding
+// it, according to the assignments recorded in every instance's state.
+func claimedHostPorts(config *Config) (map[string]string, error) {
+	specs := map[string]portSpec{}
+	for _, spec := range configuredPorts(config) {
+		specs[spec.name] = spec
+	}
+
+	claimed := map[string]string{}
+	for i := 0; i < config.VM.Instances; i++ {
+		state, err := loadInstanceState(i)
+		if err != nil {
+			return nil, err
+		}
+		for name, port := range state.Ports {
+			spec, ok := specs[name]
+			if !ok {
+				continue // no longer configured
+			}
+			claimed[spec.protocol+"/"+port] = fmt.Sprintf("the %s port of instance %d", spec.label, i+1)
+		}
+		for _, fwd := range state.Forwards {
+			claimed[fwd.Protocol+"/"+fwd.HostPort] = fmt.Sprintf("runtime forward %s of instance %d", fwd, i+1)
+		}
+	}
+	return claimed, nil
+}
+
+// instancePort returns the host port assigned to an instance, preferring the recorded
+// assignment and falling back to the configured base plus instance offset.
+func instancePort(config *Config, name string, instanceIndex int) (string, error) {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return "", err
+	}
+	if port := state.Ports[name]; port != "" {
+		return port, nil
+	}
+	for _, spec := range configuredPorts(config) {
+		if spec.name != name {
+			continue
+		}
+		if spec.base == autoPort {
+			return "", fmt.Errorf("instance %d has no %s port assigned yet; start it first", instanceIndex+1, spec.label)
+		}
+		return spec.hostPortFor(instanceIndex)
+	}
+	return "", fmt.Errorf("unknown port %q", name)
+}
+
+// vncDisplay converts a VNC TCP port into the display number QEMU expects.
+func vncDisplay(port string) (int, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil || p < vncBasePort {
+		return 0, fmt.Errorf("invalid VNC port %q", port)
+	}
+	return p - vncBasePort, nil
+}
+
+// isIPv4Address reports whether address is a literal IPv4 address. Host forwards only take
+// IPv4, and their "proto:addr:port" syntax has no room for IPv6 brackets.
+func isIPv4Address(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() != nil
+}
+
+// isLoopbackAddress reports whether a bind address only accepts local connections.
+func isLoopbackAddress(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// hostConnectAddress returns the address host-side clients should dial to reach
+// forwarded ports: the bind address itself unless it is a wildcard.
+func hostConnectAddress(config *Config) string {
+	switch config.Network.BindAddress {
+	case "", "0.0.0.0", "localhost":
+		return "localhost"
+	}
+	return config.Network.BindAddress
+}
+
+// warnExposedBindAddresses prints a prominent warning for every forward reachable from
+// other machines, since the Docker API proxy is unauthenticated.
+func warnExposedBindAddresses(config *Config) {
+	var exposed []string
+	if !isLoopbackAddress(config.Network.BindAddress) {
+		exposed = append(exposed, fmt.Sprintf("network.bindAddress=%q", config.Network.BindAddress))
+	}
+	for _, fwd := range config.Network.Forwards {
+		if fwd.BindAddress != "" && !isLoopbackAddress(fwd.BindAddress) {
+			exposed = append(exposed, fmt.Sprintf("forward %q bindAddress=%q", fwd.Name, fwd.BindAddress))
+		}
+	}
+	if !isUserNetworking(config) && config.Network.ExposeContainerAPI {
+		fmt.Println("")
+		fmt.Println("🚨🚨🚨 WARNING: the container API is reachable from other machines 🚨🚨🚨")
+		fmt.Println("🚨  network.exposeContainerAPI serves the unauthenticated API on every guest's")
+		fmt.Printf("🚨  %s address. Anyone on that network gets root on the guests.\n", config.Network.Mode)
+		fmt.Println("🚨  Leave it off and use the SSH context or the Docker socket relay instead.")
+		fmt.Println("")
+	}
+	if len(exposed) == 0 {
+		return
+	}
+	fmt.Println("")
+	fmt.Println("🚨🚨🚨 WARNING: forwarded ports are reachable from other machines 🚨🚨🚨")
+	for _, e := range exposed {
+		fmt.Printf("🚨  %s is not a loopback address\n", e)
+	}
+	fmt.Println("🚨  SSH, the unauthenticated container API and the Kubernetes API may be exposed")
+	fmt.Println("🚨  to your network. Use 127.0.0.1 unless you really mean to share them.")
+	fmt.Println("")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+)
+
+// privateConnectionPath is where the NetworkManager keyfile for the private NIC is written.
+const privateConnectionPath = "/etc/NetworkManager/system-connections/container-host-private.nmconnection"
+
+// firstPrivateHost is the host number of instance 1 inside network.private.subnet.
+const firstPrivateHost = 11
+
+// defaultMcastAddress derives the multicast group and port of the private network from
+// the project, so projects running side by side on one host get separate segments.
+func defaultMcastAddress() (string, error) {
+	id, err := projectID()
+	if err != nil {
+		return "", err
+	}
+	sum, err := hex.DecodeString(id)
+	if err != nil {
+		return "", fmt.Errorf("invalid project ID %q: %v", id, err)
+	}
+	port := 20000 + int(binary.BigEndian.Uint16(sum[2:4]))%10000
+	return fmt.Sprintf("239.255.%d.%d:%d", sum[0], sum[1], port), nil
+}
+
+// privateSubnet parses network.private.subnet.
+func privateSubnet(config *Config) (*net.IPNet, error) {
+	_, subnet, err := net.ParseCIDR(config.Network.Private.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network.private.subnet %q: %v", config.Network.Private.Subnet, err)
+	}
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("network.private.subnet %q must be IPv4", config.Network.Private.Subnet)
+	}
+	return subnet, nil
+}
+
+// privateAddress returns the static address of an instance on the private network.
+func privateAddress(config *Config, instanceIndex int) (string, error) {
+	subnet, err := privateSubnet(config)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := subnet.Mask.Size()
+	hostNumber := uint32(firstPrivateHost + instanceIndex)
+	if hostNumber >= 1<<uint(bits-ones)-1 {
+		return "", fmt.Errorf("network.private.subnet %s is too small for instance %d", subnet, instanceIndex+1)
+	}
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(subnet.IP.To4())+hostNumber)
+	return ip.String(), nil
+}
+
+// privateNetworkArgs returns the QEMU arguments for the second NIC on the shared
+// multicast segment. No host privileges are needed. The segment is bound to loopback so
+// the traffic never leaves the host, where anyone on the LAN could join the group.
+func privateNetworkArgs(config *Config, instanceIndex int) ([]string, error) {
+	mac, err := instanceMAC(instanceIndex, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"-netdev", fmt.Sprintf("socket,id=net1,mcast=%s,localaddr=127.0.0.1", config.Network.Private.McastAddress),
+		"-device", fmt.Sprintf("virtio-net-pci,netdev=net1,mac=%s", mac),
+	}, nil
+}
+
+// privateNetworkFile returns the NetworkManager keyfile giving the private NIC its static
+// address. The connection matches on MAC since guest interface names vary by machine type.
+func privateNetworkFile(config *Config, instanceIndex int) (StorageFile, error) {
+	address, err := privateAddress(config, instanceIndex)
+	if err != nil {
+		return StorageFile{}, err
+	}
+	subnet, _ := privateSubnet(config)
+	prefix, _ := subnet.Mask.Size()
+	mac, err := instanceMAC(instanceIndex, 1)
+	if err != nil {
+		return StorageFile{}, err
+	}
+
+	keyfile := fmt.Sprintf(`[connection]
+id=container-host-private
+type=ethernet
+autoconnect=true
+
+[ethernet]
+mac-address=%s
+
+[ipv4]
+method=manual
+address1=%s/%d
+never-default=true
+
+[ipv6]
+method=disabled
+`, mac, address, prefix)
+
+	return StorageFile{
+		Path:      privateConnectionPath,
+		Mode:      0600,
+		Overwrite: true,
+		Contents: FileContents{
+			Source: "data:;base64," + base64.StdEncoding.EncodeToString([]byte(keyfile)),
+		},
+	}, nil
+}
@@ -0,0 +1,6 @@
+package main
+
+import "os/exec"
+
+// detachProcess is a no-op on Windows, where helpers don't share the console's Ctrl+C.
+func detachProcess(cmd *exec.Cmd) {}
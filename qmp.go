@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxUnixSocketPath keeps socket paths under the smallest sun_path limit (macOS: 104).
+const maxUnixSocketPath = 100
+
+// instanceSocketPath returns an absolute unix socket path in the instance directory,
+// falling back to a stable name in the temp directory when the path would be too long.
+func instanceSocketPath(instanceIndex int, name string) (string, error) {
+	dir, err := filepath.Abs(instanceDir(instanceIndex))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve instance directory: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if len(path) <= maxUnixSocketPath {
+		return path, nil
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("container-host-%s-%s", hex.EncodeToString(sum[:6]), name)), nil
+}
+
+// qmpClient is a minimal QEMU Machine Protocol client over a unix socket.
+type qmpClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+type qmpResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+	Event string          `json:"event"`
+	QMP   json.RawMessage `json:"QMP"`
+}
+
+// dialQMP connects to an instance's QMP socket and negotiates capabilities.
+func dialQMP(instanceIndex int) (*qmpClient, error) {
+	path, err := instanceSocketPath(instanceIndex, "qmp.sock")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("instance %d is not running (QMP socket %s unavailable): %v", instanceIndex+1, path, err)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	client := &qmpClient{conn: conn, scanner: scanner}
+
+	// The server greets first, then waits for qmp_capabilities.
+	if _, err := client.read(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read QMP greeting: %v", err)
+	}
+	if _, err := client.execute("qmp_capabilities", nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// dialGuestAgent connects to an instance's QEMU guest agent channel. The agent speaks the
+// same JSON framing as QMP but sends no greeting, so a sync round-trip discards any reply
+// left over from an earlier client.
+func dialGuestAgent(instanceIndex int) (*qmpClient, error) {
+	path, err := instanceSocketPath(instanceIndex, "qga.sock")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("guest agent socket %s unavailable: %v", path, err)
+	}
+	// Reads block forever when no agent runs in the guest.
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	client := &qmpClient{conn: conn, scanner: scanner}
+
+	id := time.Now().UnixNano() & 0x7fffffff
+	ret, err := client.execute("guest-sync", map[string]int64{"id": id})
+	for {
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		var got int64
+		if json.Unmarshal(ret, &got) == nil && got == id {
+			return client, nil
+		}
+		var resp *qmpResponse
+		if resp, err = client.read(); err == nil {
+			ret = resp.Return
+		}
+	}
+}
+
+func (c *qmpClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *qmpClient) read() (*qmpResponse, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("QMP connection closed")
+	}
+	var resp qmpResponse
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("invalid QMP message: %v", err)
+	}
+	return &resp, nil
+}
+
+// execute runs a QMP command and returns its "return" payload, skipping async events.
+func (c *qmpClient) execute(command string, arguments interface{}) (json.RawMessage, error) {
+	request := map[string]interface{}{"execute": command}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal QMP command: %v", err)
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send QMP command %s: %v", command, err)
+	}
+
+	for {
+		resp, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if resp.Event != "" {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("QMP %s failed: %s: %s", command, resp.Error.Class, resp.Error.Desc)
+		}
+		return resp.Return, nil
+	}
+}
+
+// humanMonitorCommand runs an HMP command through QMP and returns its text output.
+func (c *qmpClient) humanMonitorCommand(commandLine string) (string, error) {
+	ret, err := c.execute("human-monitor-command", map[string]string{"command-line": commandLine})
+	if err != nil {
+		return "", err
+	}
+	var output string
+	if err := json.Unmarshal(ret, &output); err != nil {
+		return "", fmt.Errorf("unexpected HMP output: %v", err)
+	}
+	return output, nil
+}
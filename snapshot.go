@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// snapshotNamePattern keeps names safe to pass through HMP and qemu-img.
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// instanceQcow2Disks returns the existing qcow2 images of an instance: its root overlay,
+// the UEFI VARS store QEMU attaches with the current secureBoot setting, and any qcow2
+// data disks. Raw data disks cannot hold internal snapshots.
+func instanceQcow2Disks(config *Config, instanceIndex int) ([]string, error) {
+	paths := []string{filepath.Join(instanceDir(instanceIndex), "disk.qcow2")}
+	varsPath, err := instanceVarsPath(instanceIndex, config.QEMU.SecureBoot)
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, varsPath)
+	for _, d := range config.VM.Disks {
+		if d.Format == "qcow2" {
+			paths = append(paths, dataDiskPath(instanceIndex, d))
+		}
+	}
+
+	var disks []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			disks = append(disks, path)
+		}
+	}
+	if len(disks) == 0 {
+		return nil, fmt.Errorf("instance %d has no disk yet; start it first", instanceIndex+1)
+	}
+	return disks, nil
+}
+
+// runHMPSnapshotCommand runs savevm/loadvm/delvm on a running instance. These commands
+// report failures as text rather than QMP errors.
+func runHMPSnapshotCommand(qmp *qmpClient, command string) error {
+	out, err := qmp.humanMonitorCommand(command)
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("%s failed: %s", command, out)
+	}
+	return nil
+}
+
+// runQemuImgSnapshot applies a qemu-img snapshot operation to every qcow2 disk of a
+// stopped instance.
+func runQemuImgSnapshot(config *Config, instanceIndex int, flag, name string) error {
+	disks, err := instanceQcow2Disks(config, instanceIndex)
+	if err != nil {
+		return err
+	}
+	for _, disk := range disks {
+		out, err := exec.Command("qemu-img", "snapshot", flag, name, disk).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("qemu-img snapshot %s %s on %s failed: %v: %s", flag, name, disk, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// saveSnapshot records an internal snapshot. A running instance is snapshotted live with
+// savevm, which includes RAM and device state; a stopped one gets disk-only snapshots.
+func saveSnapshot(config *Config, instanceIndex int, name string) error {
+	if qmp, err := dialQMP(instanceIndex); err == nil {
+		defer qmp.Close()
+		if err := checkMountsMigratable(config, "a live snapshot"); err != nil {
+			return fmt.Errorf("%v; stop instance %d to take a disk-only snapshot", err, instanceIndex+1)
+		}
+		if err := runHMPSnapshotCommand(qmp, "savevm "+name); err != nil {
+			return err
+		}
+		fmt.Printf("📸 Saved live snapshot %q of instance %d\n", name, instanceIndex+1)
+		return nil
+	}
+	if err := runQemuImgSnapshot(config, instanceIndex, "-c", name); err != nil {
+		return err
+	}
+	fmt.Printf("📸 Saved disk snapshot %q of stopped instance %d\n", name, instanceIndex+1)
+	return nil
+}
+
+// restoreSnapshot reverts an instance. Running instances resume from the saved state
+// immediately; stopped ones boot from the snapshot's disk contents on the next start.
+func restoreSnapshot(config *Config, instanceIndex int, name string) error {
+	if qmp, err := dialQMP(instanceIndex); err == nil {
+		defer qmp.Close()
+		if err := runHMPSnapshotCommand(qmp, "loadvm "+name); err != nil {
+			return err
+		}
+		fmt.Printf("⏪ Instance %d restored to snapshot %q\n", instanceIndex+1, name)
+		return nil
+	}
+	if err := runQemuImgSnapshot(config, instanceIndex, "-a", name); err != nil {
+		return err
+	}
+	fmt.Printf("⏪ Instance %d disks reverted to snapshot %q; it boots from them on the next start\n", instanceIndex+1, name)
+	return nil
+}
+
+// deleteSnapshot removes a snapshot from every disk of an instance.
+func deleteSnapshot(config *Config, instanceIndex int, name string) error {
+	if qmp, err := dialQMP(instanceIndex); err == nil {
+		defer qmp.Close()
+		if err := runHMPSnapshotCommand(qmp, "delvm "+name); err != nil {
+			return err
+		}
+	} else if err := runQemuImgSnapshot(config, instanceIndex, "-d", name); err != nil {
+		return err
+	}
+	fmt.Printf("🗑️  Deleted snapshot %q of instance %d\n", name, instanceIndex+1)
+	return nil
+}
+
+// listSnapshots prints the snapshots of an instance, asking QEMU while it runs since the
+// disks are locked.
+func listSnapshots(config *Config, instanceIndex int) error {
+	if qmp, err := dialQMP(instanceIndex); err == nil {
+		defer qmp.Close()
+		out, err := qmp.humanMonitorCommand("info snapshots")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Instance %d snapshots (running):\n", instanceIndex+1)
+		fmt.Print(out)
+		return nil
+	}
+
+	disks, err := instanceQcow2Disks(config, instanceIndex)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("qemu-img", "snapshot", "-l", disks[0]).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("qemu-img snapshot -l failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("Instance %d snapshots (stopped):\n", instanceIndex+1)
+	if strings.TrimSpace(string(out)) == "" {
+		fmt.Println("  (none)")
+		return nil
+	}
+	fmt.Print(string(out))
+	return nil
+}
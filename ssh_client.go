@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialInstanceSSH connects to an instance as the core user with the configured key.
+func dialInstanceSSH(config *Config, instanceIndex int) (*ssh.Client, error) {
+	address, err := instanceAddress(config, instanceIndex)
+	if err != nil {
+		return nil, err
+	}
+	sshPort, err := instancePort(config, portSSH, instanceIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := os.ReadFile(config.SSH.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH private key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH private key: %v", err)
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User: "core",
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		// Host keys are regenerated whenever an instance is recreated.
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	}
+	return ssh.Dial("tcp", net.JoinHostPort(address, sshPort), clientConfig)
+}
+
+// waitForInstanceSSH retries until the instance accepts SSH logins or the timeout expires.
+// The address is re-resolved each attempt since tap and bridge guests get theirs after boot.
+func waitForInstanceSSH(config *Config, instanceIndex int, timeout time.Duration) (*ssh.Client, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		client, err := dialInstanceSSH(config, instanceIndex)
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("instance %d not reachable over SSH after %s: %v", instanceIndex+1, timeout, err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// runSSHCommand runs a command on the instance and returns its stdout.
+// If stdin is non-nil it is streamed to the remote command.
+func runSSHCommand(client *ssh.Client, command string, stdin io.Reader) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSH session: %v", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	session.Stdin = stdin
+
+	if err := session.Run(command); err != nil {
+		return stdout.String(), fmt.Errorf("%q failed: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// suspendStatePath returns the file holding an instance's migrated RAM and device state.
+func suspendStatePath(instanceIndex int) (string, error) {
+	return filepath.Abs(filepath.Join(instanceDir(instanceIndex), "suspend.state"))
+}
+
+// shellQuote quotes s for the /bin/sh that runs QEMU's exec: migration commands.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// launchHash fingerprints an instance's QEMU command line. Saved state can only be loaded
+// into an identically configured machine, so a changed hash forces a cold boot.
+func launchHash(qemuPath string, args []string) string {
+	sum := sha256.Sum256([]byte(qemuPath + "\x00" + strings.Join(args, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// prepareResume records the launch hash of an instance and, when it was suspended with
+// the same configuration, returns the arguments restoring it. Otherwise any stale state
+// file is discarded. The suspended marker is cleared either way, so the state is never
+// loaded twice.
+func prepareResume(instanceIndex int, qemuPath string, args []string) ([]string, bool, error) {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return nil, false, err
+	}
+	statePath, err := suspendStatePath(instanceIndex)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hash := launchHash(qemuPath, args)
+	suspended := state.SuspendedHash
+	state.LaunchHash = hash
+	state.SuspendedHash = ""
+	if err := saveInstanceState(instanceIndex, state); err != nil {
+		return nil, false, err
+	}
+
+	if suspended == "" {
+		return nil, false, nil
+	}
+	if _, err := os.Stat(statePath); err != nil {
+		fmt.Printf("⚠️  Suspended state of instance %d is missing, cold booting\n", instanceIndex+1)
+		return nil, false, nil
+	}
+	if suspended != hash {
+		fmt.Printf("⚠️  Configuration of instance %d changed since it was suspended, cold booting\n", instanceIndex+1)
+		_ = os.Remove(statePath)
+		return nil, false, nil
+	}
+	fmt.Printf("⏩ Resuming instance %d from %s\n", instanceIndex+1, statePath)
+	return []string{"-incoming", "exec:cat " + shellQuote(statePath)}, true, nil
+}
+
+// finishResume removes the state file once the instance runs again, since the guest
+// diverges from it immediately.
+func finishResume(instanceIndex int) {
+	deadline := time.Now().Add(5 * time.Minute)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		qmp, err := dialQMP(instanceIndex)
+		if err != nil {
+			continue
+		}
+		ret, err := qmp.execute("query-status", nil)
+		qmp.Close()
+		if err != nil {
+			continue
+		}
+		var status struct {
+			Status string `json:"status"`
+		}
+		if json.Unmarshal(ret, &status) == nil && status.Status == "running" {
+			if statePath, err := suspendStatePath(instanceIndex); err == nil {
+				_ = os.Remove(statePath)
+			}
+			fmt.Printf("⏩ Instance %d resumed\n", instanceIndex+1)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: instance %d did not resume within 5 minutes\n", instanceIndex+1)
+}
+
+// suspendInstance migrates a running instance's state to disk through QMP, then stops it.
+// The next `up` resumes it if its configuration is unchanged.
+func suspendInstance(instanceIndex int) error {
+	state, err := loadInstanceState(instanceIndex)
+	if err != nil {
+		return err
+	}
+	statePath, err := suspendStatePath(instanceIndex)
+	if err != nil {
+		return err
+	}
+	qmp, err := dialQMP(instanceIndex)
+	if err != nil {
+		return err
+	}
+	defer qmp.Close()
+
+	if _, err := qmp.execute("stop", nil); err != nil {
+		return err
+	}
+	fmt.Printf("💤 Saving instance %d state to %s...\n", instanceIndex+1, statePath)
+	if _, err := qmp.execute("migrate", map[string]string{"uri": "exec:cat > " + shellQuote(statePath)}); err != nil {
+		qmp.execute("cont", nil)
+		return err
+	}
+
+	for {
+		ret, err := qmp.execute("query-migrate", nil)
+		if err != nil {
+			return err
+		}
+		var migration struct {
+			Status    string `json:"status"`
+			ErrorDesc string `json:"error-desc"`
+		}
+		if err := json.Unmarshal(ret, &migration); err != nil {
+			return fmt.Errorf("unexpected query-migrate reply: %v", err)
+		}
+		switch migration.Status {
+		case "completed":
+			state.SuspendedHash = state.LaunchHash
+			if err := saveInstanceState(instanceIndex, state); err != nil {
+				return err
+			}
+			// QEMU exits immediately, so the reply to quit may never arrive
+			qmp.execute("quit", nil)
+			fmt.Printf("💤 Instance %d suspended\n", instanceIndex+1)
+			return nil
+		case "failed", "cancelled":
+			_ = os.Remove(statePath)
+			qmp.execute("cont", nil)
+			return fmt.Errorf("saving state failed: %s", migration.ErrorDesc)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// tpmStateDir returns the directory holding an instance's persistent TPM state.
+func tpmStateDir(instanceIndex int) (string, error) {
+	return filepath.Abs(filepath.Join(instanceDir(instanceIndex), "tpm"))
+}
+
+// tpmDevice returns the TPM frontend for a guest architecture: TIS on the ISA bus for
+// x86, or its sysbus variant on the arm virt machine.
+func tpmDevice(arch string) string {
+	if normalizeArch(arch) == "aarch64" {
+		return "tpm-tis-device"
+	}
+	return "tpm-tis"
+}
+
+// tpmArgs starts the instance's swtpm when qemu.tpm is enabled and returns the QEMU
+// arguments attaching it.
+func tpmArgs(config *Config, arch string, instanceIndex int) ([]string, error) {
+	if !config.QEMU.TPM {
+		return nil, nil
+	}
+	socketPath, err := startSwtpm(instanceIndex)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"-chardev", fmt.Sprintf("socket,id=chrtpm,path=%s", socketPath),
+		"-tpmdev", "emulator,id=tpm0,chardev=chrtpm",
+		"-device", fmt.Sprintf("%s,tpmdev=tpm0", tpmDevice(arch)),
+	}, nil
+}
+
+// startSwtpm launches swtpm for an instance and waits for its control socket. swtpm runs
+// with --terminate, so it exits when QEMU disconnects and never outlives the instance.
+func startSwtpm(instanceIndex int) (string, error) {
+	swtpm, err := exec.LookPath("swtpm")
+	if err != nil {
+		return "", fmt.Errorf("qemu.tpm is enabled but swtpm was not found in PATH")
+	}
+	stateDir, err := tpmStateDir(instanceIndex)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create TPM state directory: %v", err)
+	}
+	socketPath, err := instanceSocketPath(instanceIndex, "swtpm.sock")
+	if err != nil {
+		return "", err
+	}
+	_ = os.Remove(socketPath)
+
+	cmd := exec.Command(swtpm, "socket", "--tpm2",
+		"--tpmstate", "dir="+stateDir,
+		"--ctrl", "type=unixio,path="+socketPath,
+		"--log", "file="+filepath.Join(stateDir, "swtpm.log"),
+		"--terminate",
+	)
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start swtpm: %v", err)
+	}
+	go cmd.Wait()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if _, err := os.Stat(socketPath); err == nil {
+			return socketPath, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("swtpm did not create %s", socketPath)
+		}
+	}
+}